@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// maxChunk is the largest amount of bytes read or written at once by bandwidth limited readers and writers.
+const maxChunk = 32 * 1024
+
+// reader is an io.Reader whose throughput is limited by a Limiter, each byte consuming one slot.
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter Limiter
+}
+
+// Read reads at most one chunk of bytes, then waits for the limiter to allow the bytes read.
+func (r *reader) Read(p []byte) (int, error) {
+	if c := chunk(r.limiter); len(p) > c {
+		p = p[:c]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// writer is an io.Writer whose throughput is limited by a Limiter, each byte consuming one slot.
+type writer struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter Limiter
+}
+
+// Write writes the bytes chunk by chunk, waiting for the limiter to allow each chunk before writing it.
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		n := len(p)
+		if c := chunk(w.limiter); n > c {
+			n = c
+		}
+
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// chunk returns the amount of bytes read or written at once with the given limiter:
+// its burst if it has one, so that a chunk never waits for more than a full bucket, up to maxChunk.
+func chunk(l Limiter) int {
+	b, ok := l.(interface{ Burst() int })
+	if !ok || b.Burst() > maxChunk {
+		return maxChunk
+	}
+	if b.Burst() < 1 {
+		return 1
+	}
+	return b.Burst()
+}
+
+// bandwidthBurst returns the burst of a bandwidth limiter allowing the given bytes per second:
+// the bytes allowed over a tenth of a second, so that throughput stays smooth, up to maxChunk.
+func bandwidthBurst(rate Rate) int {
+	burst := int(rate / 10)
+	if rate == 0 || burst > maxChunk {
+		return maxChunk
+	}
+	if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// readCloser is a body whose reads are limited.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitBody returns a body whose reads are limited by the given limiter.
+func limitBody(ctx context.Context, body io.ReadCloser, l Limiter) io.ReadCloser {
+	return readCloser{
+		Reader: NewReaderContext(ctx, body, l),
+		Closer: body,
+	}
+}
+
+// limitRequestBody returns a copy of the request whose body is sent at the rate allowed by the given limiter.
+func limitRequestBody(req *http.Request, l Limiter) *http.Request {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req
+	}
+
+	r := new(http.Request)
+	*r = *req
+	r.Body = limitBody(req.Context(), req.Body, l)
+
+	if req.GetBody != nil {
+		r.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return limitBody(req.Context(), body, l), nil
+		}
+	}
+
+	return r
+}
+
+// limitResponseBody makes the response body read at the rate allowed by the given limiter.
+// Bodies of protocol switches are left untouched as they are also written to.
+func limitResponseBody(ctx context.Context, resp *http.Response, l Limiter) {
+	if resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+	resp.Body = limitBody(ctx, resp.Body, l)
+}
+
+// NewReader returns a reader reading from r at the rate allowed by the given limiter, each byte consuming one slot.
+// A token bucket allowing the desired bytes per second can be used as limiter, its burst bounding the bytes read at once.
+// The limiter can be shared, e.g. to limit the overall throughput of several readers and writers.
+func NewReader(r io.Reader, l Limiter) io.Reader {
+	return NewReaderContext(context.Background(), r, l)
+}
+
+// NewReaderContext returns a reader reading from r at the rate allowed by the given limiter,
+// each byte consuming one slot.
+// If the context ends while waiting for the limiter, reads fail with the context error.
+func NewReaderContext(ctx context.Context, r io.Reader, l Limiter) io.Reader {
+	return &reader{
+		ctx:     ctx,
+		r:       r,
+		limiter: l,
+	}
+}
+
+// NewWriter returns a writer writing to w at the rate allowed by the given limiter, each byte consuming one slot.
+// A token bucket allowing the desired bytes per second can be used as limiter, its burst bounding the bytes written at once.
+// The limiter can be shared, e.g. to limit the overall throughput of several readers and writers.
+func NewWriter(w io.Writer, l Limiter) io.Writer {
+	return NewWriterContext(context.Background(), w, l)
+}
+
+// NewWriterContext returns a writer writing to w at the rate allowed by the given limiter,
+// each byte consuming one slot.
+// If the context ends while waiting for the limiter, writes fail with the context error.
+func NewWriterContext(ctx context.Context, w io.Writer, l Limiter) io.Writer {
+	return &writer{
+		ctx:     ctx,
+		w:       w,
+		limiter: l,
+	}
+}
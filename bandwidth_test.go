@@ -0,0 +1,161 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestReaderWriter(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 1024)
+
+	testCases := []struct {
+		name     string
+		transfer func(l ratelimit.Limiter) ([]byte, error)
+	}{
+		{
+			name: "reader",
+			transfer: func(l ratelimit.Limiter) ([]byte, error) {
+				return ioutil.ReadAll(ratelimit.NewReader(bytes.NewReader(data), l))
+			},
+		},
+		{
+			name: "writer",
+			transfer: func(l ratelimit.Limiter) ([]byte, error) {
+				var buf bytes.Buffer
+				_, err := ratelimit.NewWriter(&buf, l).Write(data)
+				return buf.Bytes(), err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// 10 KiB at 20 KiB/s with a 2 KiB burst: the last 8 KiB take 400ms.
+			clock := ratelimittest.NewClock(time.Now())
+			l := ratelimit.NewTokenBucket(ratelimit.Per(20*1024, time.Second), 2*1024, ratelimit.WithClock(clock))
+			defer l.Close()
+
+			start := clock.Now()
+
+			var got []byte
+			var err error
+			clock.Run(step, func() {
+				got, err = tc.transfer(l)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, data) {
+				t.Fatalf("transferred %d bytes, expected the %d bytes of data", len(got), len(data))
+			}
+
+			assertElapsed(t, clock.Now().Sub(start), 400*time.Millisecond)
+		})
+	}
+}
+
+func TestReaderContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1, ratelimit.WithClock(ratelimittest.NewClock(time.Now())))
+	defer l.Close()
+	l.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(ratelimit.NewReaderContext(ctx, bytes.NewReader([]byte("data")), l))
+		errs <- err
+	}()
+	waitQueued(t, l, 1)
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestHTTPClientBandwidth(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 2048)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			ioutil.ReadAll(r.Body)
+			return
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	// 20 KiB at 40 KiB/s with a 4 KiB burst: the last 16 KiB take 400ms.
+	rate := ratelimit.Per(40*1024, time.Second)
+
+	testCases := []struct {
+		name   string
+		read   ratelimit.Rate
+		write  ratelimit.Rate
+		method string
+	}{
+		{name: "response body", read: rate, method: http.MethodGet},
+		{name: "request body", write: rate, method: http.MethodPost},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			clock := ratelimittest.NewClock(time.Now())
+			c := ratelimit.New(0, ratelimit.WithClock(clock), ratelimit.WithBandwidth(tc.read, tc.write))
+			defer c.Close()
+
+			req, err := http.NewRequest(tc.method, ts.URL, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := clock.Now()
+
+			clock.Run(step, func() {
+				resp, err := c.DoWithRateLimit(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			})
+
+			assertElapsed(t, clock.Now().Sub(start), 400*time.Millisecond)
+		})
+	}
+}
+
+// step is the step by which transfers advance the clock.
+const step = 10 * time.Millisecond
+
+// assertElapsed checks that a transfer advanced the clock by the expected time,
+// up to the step rounding it.
+func assertElapsed(t *testing.T, d, expected time.Duration) {
+	t.Helper()
+
+	if d < expected || d > expected+step {
+		t.Fatalf("transferred in %v, expected %v", d, expected)
+	}
+}
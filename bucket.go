@@ -0,0 +1,764 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// epsilon absorbs floating point errors when comparing token counts.
+const epsilon = 1e-9
+
+// TokenBucket is a limiter implementing the token bucket algorithm.
+// The bucket is refilled at a given rate up to its burst capacity and each event consumes one token per unit of cost.
+// Tokens accumulated while idle allow short spikes to go through immediately
+// while the long-run average stays bounded by the rate.
+// Waiters are queued and served by the bucket by priority, then in strict order of arrival,
+// as soon as enough tokens are available, unless the bucket is set to serve the most recent waiters first under overload.
+// If the rate is zero, every event is allowed.
+// A TokenBucket must be created with NewTokenBucket.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   Rate
+	burst  int
+	tokens float64
+	last   time.Time
+	queue  []*bucketReservation
+	timer  Timer
+	clock  Clock
+	closed bool
+	paused time.Time
+
+	quota      Rate
+	quotaReset time.Time
+
+	maxQueue      int
+	maxWait       time.Duration
+	lifoThreshold int
+	aging         time.Duration
+}
+
+// Wait blocks until a token is granted or the context is done.
+// If the context ends first, the pending request is withdrawn without consuming a token.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return b.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are granted or the context is done.
+// If the context ends first, the pending request is withdrawn without consuming any token.
+// The request waits with the priority set on the context with WithPriority.
+// A zero n is granted immediately and a negative n fails with ErrInvalidCost.
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	if n < 0 {
+		return ErrInvalidCost
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r := b.reserve(n, priorityFrom(ctx))
+
+	select {
+	case <-r.ready:
+		return r.err
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.granted {
+		return r.err
+	}
+	b.remove(r)
+	return ctx.Err()
+}
+
+// Allow reports whether a token is available now and consumes it if so.
+// It never jumps ahead of queued waiters.
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// AllowN reports whether n tokens are available now and consumes them if so.
+// It never jumps ahead of queued waiters.
+// A zero n is always allowed and a negative n never is.
+func (b *TokenBucket) AllowN(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || n < 0 {
+		return false
+	}
+	if n == 0 {
+		return true
+	}
+
+	b.advance(b.clock.Now())
+	if len(b.queue) > 0 || !b.available(n) {
+		return false
+	}
+	b.take(n)
+	return true
+}
+
+// Reserve reserves the next token of the bucket.
+// The reservation is queued behind previous waiters.
+// If the bucket is closed, the reservation is not OK.
+func (b *TokenBucket) Reserve() Reservation {
+	return b.reserve(1, PriorityNormal)
+}
+
+// ReserveN reserves the next n tokens of the bucket.
+// The reservation is queued behind previous waiters.
+// If the bucket is closed or n is negative, the reservation is not OK.
+// A zero n is granted immediately.
+func (b *TokenBucket) ReserveN(n int) Reservation {
+	return b.reserve(n, PriorityNormal)
+}
+
+func (b *TokenBucket) reservePriority(n int, p Priority) Reservation {
+	return b.reserve(n, p)
+}
+
+func (b *TokenBucket) reserve(n int, p Priority) *bucketReservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	r := &bucketReservation{
+		bucket:   b,
+		n:        n,
+		priority: p,
+		queued:   now,
+		ready:    make(chan struct{}),
+	}
+
+	switch {
+	case b.closed:
+		r.fail(ErrLimiterClosed)
+		return r
+	case n < 0:
+		r.fail(ErrInvalidCost)
+		return r
+	case n == 0:
+		// Events costing nothing never wait, even behind queued waiters.
+		r.grant()
+		return r
+	}
+
+	b.advance(now)
+	if len(b.queue) == 0 && b.available(n) {
+		b.take(n)
+		r.grant()
+		return r
+	}
+
+	if b.maxQueue > 0 && len(b.queue) >= b.maxQueue {
+		r.fail(ErrQueueFull)
+		return r
+	}
+
+	if b.maxWait > 0 {
+		// Fail fast when the waiters queued ahead already exceed the maximum wait.
+		if b.delay(b.ahead(r, now)+b.need(n)) > b.maxWait {
+			r.fail(ErrWaitTimeout)
+			return r
+		}
+		r.expiry = b.clock.AfterFunc(b.maxWait, func() { b.expire(r) })
+	}
+
+	// A waiter with a higher priority than the queued ones may be served right away.
+	b.queue = append(b.queue, r)
+	b.dispatch()
+
+	return r
+}
+
+// ahead returns the amount of tokens requested by the queued waiters currently served before r,
+// whether r is queued or about to be.
+func (b *TokenBucket) ahead(r *bucketReservation, now time.Time) float64 {
+	var tokens float64
+
+	behind := false
+	for _, q := range b.queue {
+		switch {
+		case q == r:
+			behind = true
+		case behind && b.precedes(q, r, now), !behind && !b.precedes(r, q, now):
+			tokens += float64(q.n)
+		}
+	}
+
+	return tokens
+}
+
+// expire withdraws a reservation still queued after the maximum wait.
+func (b *TokenBucket) expire(r *bucketReservation) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.granted {
+		return
+	}
+	b.remove(r)
+	r.fail(ErrWaitTimeout)
+}
+
+// advance refills the bucket with the tokens accumulated since the last update.
+func (b *TokenBucket) advance(now time.Time) {
+	// The server quota no longer applies once it is reset.
+	if b.last.Before(b.quotaReset) && now.After(b.quotaReset) {
+		b.refill(b.quotaReset)
+	}
+	b.refill(now)
+}
+
+// refill refills the bucket at the rate in effect since the last update.
+func (b *TokenBucket) refill(now time.Time) {
+	if !now.After(b.last) {
+		return
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.limit(b.last))
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+}
+
+// limit returns the rate at which the bucket is refilled at the given time:
+// the configured rate, lowered by the server quota until it is reset.
+func (b *TokenBucket) limit(t time.Time) Rate {
+	if !t.Before(b.quotaReset) || b.rate != 0 && b.rate < b.quota {
+		return b.rate
+	}
+	return b.quota
+}
+
+// unlimited reports whether every event is allowed at the given time.
+func (b *TokenBucket) unlimited(t time.Time) bool {
+	return b.rate == 0 && !t.Before(b.quotaReset)
+}
+
+// need returns the amount of tokens the bucket must hold before n tokens can be taken.
+// Costs larger than the burst only require a full bucket and are paid back by the following events.
+func (b *TokenBucket) need(n int) float64 {
+	if n > b.burst {
+		return float64(b.burst)
+	}
+	return float64(n)
+}
+
+// available reports whether n tokens can be taken.
+func (b *TokenBucket) available(n int) bool {
+	now := b.clock.Now()
+	if now.Before(b.paused) {
+		return false
+	}
+	return b.unlimited(now) || b.tokens >= b.need(n)-epsilon
+}
+
+// take consumes n tokens.
+func (b *TokenBucket) take(n int) {
+	if !b.unlimited(b.clock.Now()) {
+		b.tokens -= float64(n)
+	}
+}
+
+// dispatch grants tokens to queued waiters in order and schedules the next dispatch.
+func (b *TokenBucket) dispatch() {
+	b.advance(b.clock.Now())
+
+	for len(b.queue) > 0 {
+		i := b.next()
+		r := b.queue[i]
+		if !b.available(r.n) {
+			break
+		}
+
+		b.take(r.n)
+		r.grant()
+		b.pop(i)
+	}
+
+	b.schedule()
+}
+
+// overloaded reports whether the queue is long enough to serve the most recent waiters first.
+func (b *TokenBucket) overloaded() bool {
+	return b.lifoThreshold > 0 && len(b.queue) > b.lifoThreshold
+}
+
+// next returns the index of the next waiter to serve:
+// the one with the highest priority and, among them, the first one in order of arrival,
+// or the last one if the bucket is overloaded.
+func (b *TokenBucket) next() int {
+	now := b.clock.Now()
+
+	next := 0
+	for i := 1; i < len(b.queue); i++ {
+		if b.precedes(b.queue[i], b.queue[next], now) {
+			next = i
+		}
+	}
+	return next
+}
+
+// precedes reports whether the waiter q, queued after r, is served before r.
+// Aging only orders waiters of different priorities: waiters of the same priority
+// are served in order of arrival, or the most recent first if the bucket is overloaded.
+func (b *TokenBucket) precedes(q, r *bucketReservation, now time.Time) bool {
+	if q.priority != r.priority {
+		if qp, rp := b.priority(q, now), b.priority(r, now); qp != rp {
+			return qp > rp
+		}
+	}
+	return b.overloaded()
+}
+
+// priority returns the priority of a waiter raised by one level per aging period spent in the queue.
+func (b *TokenBucket) priority(r *bucketReservation, now time.Time) Priority {
+	if b.aging <= 0 {
+		return r.priority
+	}
+	return r.priority + Priority(now.Sub(r.queued)/b.aging)
+}
+
+// pop removes the waiter at the given index from the queue.
+func (b *TokenBucket) pop(i int) {
+	if i == 0 {
+		b.queue[0] = nil
+		b.queue = b.queue[1:]
+		return
+	}
+
+	copy(b.queue[i:], b.queue[i+1:])
+	b.queue[len(b.queue)-1] = nil
+	b.queue = b.queue[:len(b.queue)-1]
+}
+
+// schedule arms the bucket timer for the time the first waiter can be served.
+func (b *TokenBucket) schedule() {
+	if len(b.queue) == 0 {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		return
+	}
+
+	d := b.delay(b.need(b.queue[b.next()].n))
+
+	if b.timer == nil {
+		b.timer = b.clock.AfterFunc(d, b.onTimer)
+		return
+	}
+	b.timer.Stop()
+	b.timer.Reset(d)
+}
+
+func (b *TokenBucket) onTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.dispatch()
+}
+
+// delay returns the duration until the bucket holds the given amount of tokens and is not paused.
+func (b *TokenBucket) delay(tokens float64) time.Duration {
+	now := b.clock.Now()
+
+	var d time.Duration
+	if !b.unlimited(now) && b.tokens < tokens-epsilon {
+		d = b.refilled(tokens).Sub(now)
+	}
+
+	if p := b.paused.Sub(now); p > d {
+		d = p
+	}
+
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// refilled returns the time at which the bucket holds the given amount of tokens,
+// refilled at the quota rate until the quota is reset and at the configured rate afterwards.
+func (b *TokenBucket) refilled(tokens float64) time.Time {
+	missing := tokens - b.tokens
+	from := b.last
+
+	if from.Before(b.quotaReset) {
+		rate := float64(b.limit(from))
+		if quota := b.quotaReset.Sub(from).Seconds() * rate; quota < missing-epsilon {
+			missing -= quota
+			from = b.quotaReset
+		} else {
+			return from.Add(seconds(missing / rate))
+		}
+
+		if b.rate == 0 {
+			return from
+		}
+	}
+
+	return from.Add(seconds(missing / float64(b.rate)))
+}
+
+// seconds converts a number of seconds to a duration.
+// It rounds up to the nanosecond so that the bucket holds the tokens once the duration has elapsed,
+// ignoring floating point errors.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s*float64(time.Second) - 1e-3))
+}
+
+// SetRate changes the rate at which the bucket is refilled.
+// Tokens accumulated so far are kept and queued waiters are served according to the new rate.
+// A zero rate allows every event and releases every queued waiter.
+func (b *TokenBucket) SetRate(rate Rate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	b.rate = rate
+
+	if !b.closed {
+		b.dispatch()
+	}
+}
+
+// SetBurst changes the capacity of the bucket.
+// Tokens exceeding the new capacity are dropped.
+func (b *TokenBucket) SetBurst(burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	b.burst = burst
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+
+	if !b.closed {
+		b.dispatch()
+	}
+}
+
+// PauseUntil stops granting tokens until the given time, e.g. when a server asks clients to back off.
+// The bucket is not refilled while paused and resumes with at most one token.
+// Pausing until an earlier time than an ongoing pause has no effect.
+func (b *TokenBucket) PauseUntil(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	if !t.After(b.paused) || !t.After(b.last) {
+		return
+	}
+
+	b.paused = t
+	b.last = t
+	if b.tokens > 1 {
+		b.tokens = 1
+	}
+
+	if !b.closed {
+		b.dispatch()
+	}
+}
+
+// SetQuota spreads the remaining quota evenly until the reset time.
+// Until the reset, the bucket is refilled at the lower of its rate and the quota rate,
+// so that no more than remaining events are allowed before the reset.
+// Accumulated tokens count against the quota and are only dropped if they exceed it.
+// If the quota is exhausted, the bucket is paused until the reset.
+func (b *TokenBucket) SetQuota(remaining int, reset time.Time) {
+	if remaining <= 0 {
+		b.PauseUntil(reset)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if !reset.After(now) {
+		return
+	}
+
+	b.advance(now)
+	if b.tokens > float64(remaining) {
+		b.tokens = float64(remaining)
+	}
+
+	// Only the quota left once the accumulated tokens are spent is spread until the reset.
+	b.quota = Per(float64(remaining)-math.Max(b.tokens, 0), reset.Sub(now))
+	b.quotaReset = reset
+
+	if !b.closed {
+		b.dispatch()
+	}
+}
+
+// SetMaxQueue bounds the number of queued waiters.
+// Once the queue is full, new waiters fail immediately with ErrQueueFull.
+// Waiters already queued are kept when the bound is lowered.
+// A zero bound means no limit.
+func (b *TokenBucket) SetMaxQueue(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.maxQueue = n
+}
+
+// SetMaxWait bounds the time a waiter may spend in the queue.
+// Waiters still queued after the maximum wait fail with ErrWaitTimeout without consuming any token,
+// and new waiters whose estimated delay already exceeds it fail immediately.
+// A zero duration means no limit.
+func (b *TokenBucket) SetMaxWait(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.maxWait = d
+}
+
+// SetLIFOThreshold makes the bucket serve the most recent waiters first while more than n waiters are queued.
+// Under overload, older waiters are likely to have given up already: serving the newest ones first
+// keeps the latency of most callers low, at the expense of the oldest waiters.
+// Waiters are served in order of arrival again once the queue shrinks back to n waiters.
+// A zero threshold means strict order of arrival, the default.
+func (b *TokenBucket) SetLIFOThreshold(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lifoThreshold = n
+
+	if !b.closed {
+		b.dispatch()
+	}
+}
+
+// SetPriorityAging changes the waiting time after which a queued waiter is raised by one priority level,
+// DefaultPriorityAging by default.
+// Waiters keep being raised every d, so that a waiter is served first after at most one period
+// per priority level separating it from the waiters arriving after it.
+// A zero duration disables aging: higher priority waiters are always served first and may starve lower ones.
+func (b *TokenBucket) SetPriorityAging(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.aging = d
+}
+
+// Waiting returns the number of queued waiters.
+func (b *TokenBucket) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.queue)
+}
+
+// atRest reports whether the bucket is full and has no waiter,
+// in which case replacing it with a new bucket would not allow extra events.
+func (b *TokenBucket) atRest() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.clock.Now())
+	return len(b.queue) == 0 && b.tokens >= float64(b.burst)-epsilon && !b.clock.Now().Before(b.paused)
+}
+
+// Rate returns the rate at which the bucket is refilled, ignoring any server quota.
+func (b *TokenBucket) Rate() Rate {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rate
+}
+
+// Burst returns the capacity of the bucket.
+func (b *TokenBucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.burst
+}
+
+// Close stops the bucket timer and releases every queued waiter with ErrLimiterClosed.
+// Subsequent calls fail fast: Wait returns ErrLimiterClosed, Allow reports false
+// and reservations are not OK.
+func (b *TokenBucket) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	for _, r := range b.queue {
+		r.fail(ErrLimiterClosed)
+	}
+	b.queue = nil
+
+	return nil
+}
+
+// remove withdraws a pending reservation from the queue.
+func (b *TokenBucket) remove(r *bucketReservation) {
+	if r.expiry != nil {
+		r.expiry.Stop()
+	}
+	for i, q := range b.queue {
+		if q == r {
+			b.pop(i)
+			break
+		}
+	}
+	b.dispatch()
+}
+
+// bucketReservation is a token reserved on a TokenBucket.
+// It is queued until the bucket grants it a token.
+type bucketReservation struct {
+	bucket   *TokenBucket
+	n        int
+	priority Priority
+	queued   time.Time
+	ready    chan struct{}
+	granted  bool
+	canceled bool
+	err      error
+	expiry   Timer
+}
+
+func (r *bucketReservation) grant() {
+	r.granted = true
+	close(r.ready)
+
+	if r.expiry != nil {
+		r.expiry.Stop()
+	}
+}
+
+// fail releases the reservation without a token.
+func (r *bucketReservation) fail(err error) {
+	r.err = err
+	r.grant()
+}
+
+// OK reports whether the reservation holds or waits for a token.
+// Reservations made on or released by a closed bucket are not OK.
+func (r *bucketReservation) OK() bool {
+	r.bucket.mu.Lock()
+	defer r.bucket.mu.Unlock()
+
+	return r.err == nil
+}
+
+// Delay returns the estimated duration until the reservation is granted its tokens.
+// The estimation assumes the waiters currently served before it are granted their tokens first
+// and that no waiter with a higher priority arrives in the meantime.
+func (r *bucketReservation) Delay() time.Duration {
+	b := r.bucket
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.granted {
+		return 0
+	}
+
+	now := b.clock.Now()
+	b.advance(now)
+
+	return b.delay(b.ahead(r, now) + b.need(r.n))
+}
+
+// Wait blocks until the reservation is granted its tokens or the context is done.
+func (r *bucketReservation) Wait(ctx context.Context) error {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.bucket.mu.Lock()
+	defer r.bucket.mu.Unlock()
+
+	return r.err
+}
+
+// Cancel gives the reservation back to the bucket.
+// A pending reservation is withdrawn from the queue, a granted one returns its tokens.
+func (r *bucketReservation) Cancel() {
+	b := r.bucket
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.canceled || r.err != nil || b.closed {
+		return
+	}
+	r.canceled = true
+
+	if !r.granted {
+		b.remove(r)
+		r.fail(ErrReservationCanceled)
+		return
+	}
+
+	now := b.clock.Now()
+	b.advance(now)
+	if !b.unlimited(now) {
+		b.tokens += float64(r.n)
+		if b.tokens > float64(b.burst) {
+			b.tokens = float64(b.burst)
+		}
+	}
+	b.dispatch()
+}
+
+// NewTokenBucket returns a token bucket limiter allowing events at the given rate
+// with bursts of up to burst events.
+// The bucket starts full.
+// A burst lower than 1 is raised to 1.
+// If the provided rate is zero, every event is allowed.
+// Options tuning the limiter, such as WithClock or WithMaxQueue, configure the bucket.
+func NewTokenBucket(rate Rate, burst int, opts ...Option) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	o := newOptions(opts)
+	o.burst = burst
+	return o.newBucket(rate)
+}
+
+func newTokenBucket(rate Rate, burst int, tokens int, clock Clock) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(tokens),
+		last:   clock.Now(),
+		clock:  clock,
+		aging:  DefaultPriorityAging,
+	}
+}
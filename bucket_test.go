@@ -0,0 +1,610 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+var (
+	_ ratelimit.Limiter   = (*ratelimit.TokenBucket)(nil)
+	_ ratelimit.Tunable   = (*ratelimit.TokenBucket)(nil)
+	_ ratelimit.Bounded   = (*ratelimit.TokenBucket)(nil)
+	_ ratelimit.Pausable  = (*ratelimit.TokenBucket)(nil)
+	_ ratelimit.Adaptable = (*ratelimit.TokenBucket)(nil)
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		rate    float64
+		burst   int
+		allowed int
+	}{
+		{name: "burst 1, 1 QPS", rate: 1.0, burst: 1, allowed: 1},
+		{name: "burst 5, 1 QPS", rate: 1.0, burst: 5, allowed: 5},
+		{name: "burst 0 raised to 1, 1 QPS", rate: 1.0, burst: 0, allowed: 1},
+	}
+
+	for _, tc := range testCases {
+		b := ratelimit.NewTokenBucket(ratelimit.Rate(tc.rate), tc.burst)
+
+		for i := 0; i < tc.allowed; i++ {
+			if !b.Allow() {
+				t.Fatalf("%s - event %d not allowed, expected burst of %d", tc.name, i, tc.allowed)
+			}
+		}
+
+		if b.Allow() {
+			t.Fatalf("%s - event allowed beyond burst of %d", tc.name, tc.allowed)
+		}
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	t.Parallel()
+
+	rate := ratelimit.Per(10, time.Second)
+	burst := 3
+	n := 6
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewTokenBucket(rate, burst, ratelimit.WithClock(clock)), clock)
+
+	start := clock.Now()
+
+	clock.Run(ratelimittest.Interval(rate), func() {
+		for i := 0; i < n; i++ {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	// The burst goes through immediately, the remaining events wait for the bucket to refill.
+	offsets := append(make([]time.Duration, burst), ratelimittest.TickerOffsets(n-burst, rate)...)
+	ratelimittest.AssertTimes(t, l.Events(), start, offsets...)
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	b := ratelimit.NewTokenBucket(10.0, 1, ratelimit.WithClock(clock))
+
+	first := b.Reserve()
+	if d := first.Delay(); d != 0 {
+		t.Fatalf("first reservation delay %v, expected 0", d)
+	}
+
+	second := b.Reserve()
+	if d := second.Delay(); d != 100*time.Millisecond {
+		t.Fatalf("second reservation delay %v, expected 100ms", d)
+	}
+
+	second.Cancel()
+	first.Cancel()
+
+	if !b.Allow() {
+		t.Fatal("event not allowed after canceling reservations")
+	}
+}
+
+func TestHTTPClientWithTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewTLSServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	burst := 5
+	c := ratelimit.NewHTTPClientWithLimiter(ratelimit.NewTokenBucket(1.0, burst, ratelimit.WithClock(clock)))
+	c.Transport = ts.Client().Transport
+
+	start := clock.Now()
+
+	clock.Run(time.Second, func() {
+		for i := 0; i < burst; i++ {
+			resp, err := c.GetWithRateLimit(ts.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}
+	})
+
+	// The whole burst is sent without waiting.
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, make([]time.Duration, burst)...)
+}
+
+func TestTokenBucketClose(t *testing.T) {
+	t.Parallel()
+
+	b := ratelimit.NewTokenBucket(0.1, 1, ratelimit.WithClock(ratelimittest.NewClock(time.Now())))
+	b.Allow()
+
+	errs := make(chan error)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- b.Wait(context.Background())
+		}()
+	}
+
+	waitQueued(t, b, 3)
+	b.Close()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if err != ratelimit.ErrLimiterClosed {
+				t.Fatalf("got error %v, expected %v", err, ratelimit.ErrLimiterClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter not released by Close")
+		}
+	}
+
+	if err := b.Wait(context.Background()); err != ratelimit.ErrLimiterClosed {
+		t.Fatalf("got error %v after close, expected %v", err, ratelimit.ErrLimiterClosed)
+	}
+
+	if b.Allow() {
+		t.Fatal("event allowed after close")
+	}
+
+	if b.Reserve().OK() {
+		t.Fatal("reservation OK after close")
+	}
+}
+
+func TestTokenBucketSetRate(t *testing.T) {
+	t.Parallel()
+
+	n := 5
+	clock := ratelimittest.NewClock(time.Now())
+	b := ratelimit.NewTokenBucket(0.1, 1, ratelimit.WithClock(clock))
+	b.Allow()
+
+	errs := make(chan error)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- b.Wait(context.Background())
+		}()
+	}
+
+	waitQueued(t, b, n)
+	b.SetRate(50.0)
+
+	// At the new rate, every waiter is served within n intervals instead of n*10s.
+	clock.Advance(time.Duration(n) * 20 * time.Millisecond)
+
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d waiters released after rate change, expected %d", i, n)
+		}
+	}
+
+	// Every waiter consumed its own token: none is left to allow another event.
+	if b.Allow() {
+		t.Fatal("event allowed after serving every waiter, expected an empty bucket")
+	}
+}
+
+func TestTokenBucketSetBurst(t *testing.T) {
+	t.Parallel()
+
+	burst := 5
+	clock := ratelimittest.NewClock(time.Now())
+	b := ratelimit.NewTokenBucket(100.0, 1, ratelimit.WithClock(clock))
+	b.SetBurst(burst)
+
+	if got := b.Burst(); got != burst {
+		t.Fatalf("got burst %d, expected %d", got, burst)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+
+	for i := 0; i < burst; i++ {
+		if !b.Allow() {
+			t.Fatalf("event %d not allowed, expected burst of %d", i, burst)
+		}
+	}
+}
+
+func TestTokenBucketCost(t *testing.T) {
+	t.Parallel()
+
+	rate := ratelimit.Per(10, time.Second)
+	burst := 5
+
+	b := ratelimit.NewTokenBucket(rate, burst)
+
+	b.Allow()
+
+	if b.AllowN(burst) {
+		t.Fatalf("event costing %d allowed with %d tokens", burst, burst-1)
+	}
+
+	if !b.AllowN(burst - 1) {
+		t.Fatalf("event costing %d not allowed with %d tokens", burst-1, burst-1)
+	}
+
+	// Costs larger than the burst wait for a full bucket and are paid back by the following events.
+	b = ratelimit.NewTokenBucket(rate, burst)
+
+	if err := b.WaitN(context.Background(), 2*burst); err != nil {
+		t.Fatal(err)
+	}
+
+	r := b.Reserve()
+	defer r.Cancel()
+
+	expected := 600 * time.Millisecond
+	if d := r.Delay(); d > expected || d < expected-50*time.Millisecond {
+		t.Fatalf("got delay %v after an event costing %d, expected %v", d, 2*burst, expected)
+	}
+}
+
+func TestTokenBucketInvalidCost(t *testing.T) {
+	t.Parallel()
+
+	b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+	defer b.Close()
+
+	if !b.Allow() {
+		t.Fatal("event refused, expected the bucket to start full")
+	}
+
+	if b.AllowN(-100) {
+		t.Fatal("event with a negative cost allowed")
+	}
+	if b.Allow() {
+		t.Fatal("event allowed on a drained bucket after a negative cost")
+	}
+
+	if err := b.WaitN(context.Background(), -1); err != ratelimit.ErrInvalidCost {
+		t.Fatalf("got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	r := b.ReserveN(-1)
+	if r.OK() {
+		t.Fatal("reservation with a negative cost is OK")
+	}
+	if err := r.Wait(context.Background()); err != ratelimit.ErrInvalidCost {
+		t.Fatalf("got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	// Events costing nothing go through a drained bucket without waiting.
+	if !b.AllowN(0) {
+		t.Fatal("event costing nothing refused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := b.WaitN(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if b.Allow() {
+		t.Fatal("event allowed on a drained bucket after events costing nothing")
+	}
+}
+
+func TestTokenBucketPauseUntil(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	b := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10, ratelimit.WithClock(clock))
+
+	pause := 200 * time.Millisecond
+	start := clock.Now()
+	b.PauseUntil(start.Add(pause))
+
+	if b.Allow() {
+		t.Fatal("event allowed while paused")
+	}
+
+	clock.Run(10*time.Millisecond, func() {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if d := clock.Now().Sub(start); d != pause {
+		t.Fatalf("event allowed after %v, expected a pause of %v", d, pause)
+	}
+
+	// The bucket resumes with a single token instead of a full burst.
+	if b.Allow() {
+		t.Fatal("burst allowed right after a pause")
+	}
+}
+
+func TestTokenBucketSetQuota(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	start := clock.Now()
+
+	b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Second), 5, ratelimit.WithClock(clock))
+	defer b.Close()
+
+	// A generous quota neither raises the rate nor drops accumulated tokens.
+	b.SetQuota(5000, start.Add(10*time.Second))
+
+	if !b.AllowN(5) {
+		t.Fatal("accumulated tokens dropped by a generous quota")
+	}
+	if d := delay(b); d != time.Second {
+		t.Fatalf("got delay %v under a generous quota, expected the configured 1s", d)
+	}
+
+	// A tight quota lowers the rate until its reset, then the configured rate applies again.
+	clock.Advance(2 * time.Second)
+	b.SetQuota(1, start.Add(10*time.Second))
+
+	if !b.Allow() {
+		t.Fatal("accumulated token within the quota dropped")
+	}
+	if d := delay(b); d != 9*time.Second {
+		t.Fatalf("got delay %v with an exhausted quota, expected 8s until the reset and 1s to refill", d)
+	}
+
+	clock.Advance(9 * time.Second)
+	if !b.Allow() {
+		t.Fatal("event refused after the quota reset")
+	}
+	if d := delay(b); d != time.Second {
+		t.Fatalf("got delay %v after the quota reset, expected the configured 1s", d)
+	}
+
+	// Tokens exceeding the remaining quota are dropped.
+	clock.Advance(5 * time.Second)
+	b.SetQuota(2, clock.Now().Add(time.Minute))
+
+	if !b.AllowN(2) {
+		t.Fatal("accumulated tokens within the quota dropped")
+	}
+	if b.Allow() {
+		t.Fatal("event allowed beyond the remaining quota")
+	}
+}
+
+// delay returns the delay of a reservation made on the given limiter, without consuming it.
+func delay(l ratelimit.Limiter) time.Duration {
+	r := l.Reserve()
+	defer r.Cancel()
+
+	return r.Delay()
+}
+
+func TestTokenBucketMaxQueue(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1)
+	defer l.Close()
+
+	l.SetMaxQueue(2)
+	l.Allow()
+
+	first, second := l.Reserve(), l.Reserve()
+	if !first.OK() || !second.OK() {
+		t.Fatal("reservation refused, expected the first 2 waiters to be queued")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := l.Wait(ctx); err != ratelimit.ErrQueueFull {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrQueueFull)
+	}
+
+	// Leaving the queue makes room for a new waiter.
+	second.Cancel()
+	if r := l.Reserve(); !r.OK() {
+		t.Fatal("reservation refused after a waiter left the queue")
+	}
+}
+
+func TestTokenBucketMaxWait(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		rate    ratelimit.Rate
+		maxWait time.Duration
+		err     error
+		delay   time.Duration
+	}{
+		{name: "estimated delay too long", rate: ratelimit.Per(1, time.Minute), maxWait: time.Second, err: ratelimit.ErrWaitTimeout, delay: 0},
+		{name: "granted in time", rate: ratelimit.Per(20, time.Second), maxWait: time.Second, err: nil, delay: 50 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratelimittest.NewClock(time.Now())
+			l := ratelimit.NewTokenBucket(tc.rate, 1, ratelimit.WithClock(clock))
+			defer l.Close()
+
+			l.SetMaxWait(tc.maxWait)
+			l.Allow()
+
+			start := clock.Now()
+			clock.Run(10*time.Millisecond, func() {
+				if err := l.Wait(context.Background()); err != tc.err {
+					t.Errorf("got %v, expected %v", err, tc.err)
+				}
+			})
+			if d := clock.Now().Sub(start); d != tc.delay {
+				t.Fatalf("returned after %v, expected %v", d, tc.delay)
+			}
+		})
+	}
+}
+
+func TestTokenBucketMaxWaitExpires(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(ratelimit.Per(10, time.Second), 1, ratelimit.WithClock(clock))
+	defer l.Close()
+
+	maxWait := 200 * time.Millisecond
+	l.SetMaxWait(maxWait)
+	l.Allow()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- l.Wait(context.Background())
+	}()
+
+	// The rate drops after the waiter was queued, its slot is not granted in time.
+	waitQueued(t, l, 1)
+	l.SetRate(ratelimit.Per(1, time.Minute))
+	clock.Advance(maxWait)
+
+	select {
+	case err := <-errs:
+		if err != ratelimit.ErrWaitTimeout {
+			t.Fatalf("got %v, expected %v", err, ratelimit.ErrWaitTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still queued after the maximum wait")
+	}
+
+	// The expired waiter did not consume any token: the next waiter is now first in line.
+	if r := l.Reserve(); r.Delay() > time.Minute {
+		t.Fatalf("got delay %v, expected the expired waiter to have left the queue", r.Delay())
+	}
+}
+
+func TestTokenBucketMaxWaitCanceled(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1, ratelimit.WithClock(clock))
+	defer l.Close()
+
+	l.SetMaxWait(time.Hour)
+	l.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- l.Wait(ctx)
+	}()
+
+	// The waiter is queued with its expiry timer and the bucket timer.
+	clock.BlockUntil(2)
+	cancel()
+
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+
+	// The withdrawn waiter does not keep its expiry timer scheduled.
+	if got := clock.Timers(); got != 0 {
+		t.Fatalf("got %d timers scheduled after the waiter left, expected 0", got)
+	}
+}
+
+func TestTokenBucketOrder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		waiters   int
+		threshold int
+		expected  []int
+	}{
+		{name: "fifo", waiters: 100, expected: sequence(0, 100)},
+		{name: "lifo under overload", waiters: 10, threshold: 5, expected: append([]int{9, 8, 7, 6, 5}, sequence(0, 5)...)},
+		{name: "threshold not reached", waiters: 10, threshold: 10, expected: sequence(0, 10)},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), tc.waiters)
+			defer l.Close()
+
+			l.SetLIFOThreshold(tc.threshold)
+
+			// Hold every token so that waiters are granted one at a time, when a held token is given back.
+			held := make([]ratelimit.Reservation, tc.waiters)
+			for i := range held {
+				held[i] = l.Reserve()
+			}
+
+			granted := make(chan int)
+			for i := 0; i < tc.waiters; i++ {
+				go func(id int) {
+					if err := l.Wait(context.Background()); err == nil {
+						granted <- id
+					}
+				}(i)
+
+				waitQueued(t, l, i+1)
+			}
+
+			for i, r := range held {
+				r.Cancel()
+
+				select {
+				case id := <-granted:
+					if id != tc.expected[i] {
+						t.Fatalf("slot %d granted to waiter %d, expected waiter %d", i, id, tc.expected[i])
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("slot %d not granted", i)
+				}
+			}
+		})
+	}
+}
+
+// waitQueued waits until the given number of waiters are queued on the bucket.
+func waitQueued(t *testing.T, l interface{ Waiting() int }, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for l.Waiting() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters queued, expected %d", l.Waiting(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// sequence returns the integers from start to end excluded.
+func sequence(start, end int) []int {
+	s := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		s = append(s, i)
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package ratelimit
+
+import "time"
+
+// Clock tells the time and schedules functions.
+// Limiters use it to refill over time and to wake up waiters,
+// which allows replacing the system clock with a fake one in tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function call scheduled by a Clock.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock telling the real time, used by default.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+// Now returns the current time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc calls f in its own goroutine after the duration elapses.
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// MultiLimiter is a limiter made of several limiters.
+// An event is allowed only if every constituent limiter allows it,
+// e.g. 10 requests per second and 1000 requests per hour.
+// When a constituent limiter refuses an event, the slots taken on the other ones are given back.
+// A MultiLimiter must be created with NewMultiLimiter.
+type MultiLimiter struct {
+	limiters []Limiter
+}
+
+// Wait blocks until every constituent limiter allows an event or the context is done.
+// If the context ends first, the slots reserved on the constituent limiters are given back.
+func (m *MultiLimiter) Wait(ctx context.Context) error {
+	return m.WaitN(ctx, 1)
+}
+
+// WaitN blocks until every constituent limiter allows an event costing n slots or the context is done.
+// If the context ends first, the slots reserved on the constituent limiters are given back.
+func (m *MultiLimiter) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r := m.reservePriority(n, priorityFrom(ctx))
+	if err := r.Wait(ctx); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+// Allow reports whether every constituent limiter allows an event now.
+// If one of them refuses, the slots taken on the others are given back.
+func (m *MultiLimiter) Allow() bool {
+	return m.AllowN(1)
+}
+
+// AllowN reports whether every constituent limiter allows an event costing n slots now.
+// If one of them refuses, the slots taken on the others are given back.
+func (m *MultiLimiter) AllowN(n int) bool {
+	rs := make([]Reservation, 0, len(m.limiters))
+
+	for _, l := range m.limiters {
+		r := l.ReserveN(n)
+		rs = append(rs, r)
+
+		if !r.OK() || r.Delay() > 0 {
+			cancelAll(rs)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reserve reserves a slot on every constituent limiter.
+// The reservation is usable once every slot is usable.
+// If a constituent limiter cannot reserve a slot, the other slots are given back and the reservation is not OK.
+func (m *MultiLimiter) Reserve() Reservation {
+	return m.ReserveN(1)
+}
+
+// ReserveN reserves n slots on every constituent limiter.
+// The reservation is usable once every slot is usable.
+// If a constituent limiter cannot reserve the slots, the other slots are given back and the reservation is not OK.
+func (m *MultiLimiter) ReserveN(n int) Reservation {
+	return m.reservePriority(n, PriorityNormal)
+}
+
+func (m *MultiLimiter) reservePriority(n int, p Priority) Reservation {
+	rs := make([]Reservation, 0, len(m.limiters))
+
+	for _, l := range m.limiters {
+		r := reservePriority(l, n, p)
+		rs = append(rs, r)
+
+		if !r.OK() {
+			cancelAll(rs)
+			return failedReservation{err: r.Wait(context.Background())}
+		}
+	}
+
+	return &multiReservation{reservations: rs}
+}
+
+// PauseUntil pauses every pausable constituent limiter until the given time.
+func (m *MultiLimiter) PauseUntil(t time.Time) {
+	for _, l := range m.limiters {
+		pauseLimiter(l, t)
+	}
+}
+
+// Close closes every constituent limiter.
+// It returns the first error encountered.
+func (m *MultiLimiter) Close() error {
+	var err error
+
+	for _, l := range m.limiters {
+		if e := closeLimiter(l); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// multiReservation is a set of slots reserved on the constituent limiters of a MultiLimiter.
+type multiReservation struct {
+	reservations []Reservation
+}
+
+// OK always reports true as every slot has been reserved.
+func (r *multiReservation) OK() bool {
+	return true
+}
+
+// Delay returns the duration until every reserved slot is usable.
+func (r *multiReservation) Delay() time.Duration {
+	var d time.Duration
+
+	for _, sr := range r.reservations {
+		if srd := sr.Delay(); srd > d {
+			d = srd
+		}
+	}
+
+	return d
+}
+
+// Wait blocks until every reserved slot is usable or the context is done.
+func (r *multiReservation) Wait(ctx context.Context) error {
+	for _, sr := range r.reservations {
+		if err := sr.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel gives every reserved slot back to its limiter.
+func (r *multiReservation) Cancel() {
+	cancelAll(r.reservations)
+}
+
+// cancelAll cancels the given reservations.
+func cancelAll(rs []Reservation) {
+	for _, r := range rs {
+		r.Cancel()
+	}
+}
+
+// NewMultiLimiter returns a limiter allowing an event only if every given limiter allows it.
+// It can be used wherever a single limiter is accepted to enforce several limits at once.
+func NewMultiLimiter(limiters ...Limiter) *MultiLimiter {
+	return &MultiLimiter{limiters: limiters}
+}
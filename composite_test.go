@@ -0,0 +1,100 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+var _ ratelimit.Limiter = (*ratelimit.MultiLimiter)(nil)
+
+func TestMultiLimiterAllow(t *testing.T) {
+	t.Parallel()
+
+	perSecond := ratelimit.NewTokenBucket(ratelimit.Per(10, time.Second), 10)
+	perHour := ratelimit.NewTokenBucket(ratelimit.Per(3, time.Hour), 3)
+
+	m := ratelimit.NewMultiLimiter(perSecond, perHour)
+
+	for i := 0; i < 3; i++ {
+		if !m.Allow() {
+			t.Fatalf("event %d not allowed, expected 3 allowed events", i)
+		}
+	}
+
+	if m.Allow() {
+		t.Fatal("event allowed beyond the hourly limit")
+	}
+
+	// The slot taken on the per second limiter by the refused event has been given back.
+	for i := 0; i < 7; i++ {
+		if !perSecond.Allow() {
+			t.Fatalf("event %d not allowed by the per second limiter, expected 7 remaining events", i)
+		}
+	}
+}
+
+func TestMultiLimiterWaitRollback(t *testing.T) {
+	t.Parallel()
+
+	fast := ratelimit.NewTokenBucket(ratelimit.Per(10, time.Second), 1)
+	slow := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+	slow.Allow()
+
+	m := ratelimit.NewMultiLimiter(fast, slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := m.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	if !fast.Allow() {
+		t.Fatal("slot of the fast limiter not given back")
+	}
+}
+
+func TestHTTPClientWithMultiLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewTLSServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	rate := ratelimit.Per(10, time.Second)
+	n := 4
+
+	m := ratelimit.NewMultiLimiter(
+		ratelimit.NewTokenBucket(rate, 1, ratelimit.WithClock(clock)),
+		ratelimit.NewTokenBucket(ratelimit.Per(1000, time.Hour), 1000, ratelimit.WithClock(clock)),
+	)
+
+	c := ratelimit.NewHTTPClientWithLimiter(m)
+	c.Transport = ts.Client().Transport
+
+	start := clock.Now()
+
+	clock.Run(ratelimittest.Interval(rate), func() {
+		for i := 0; i < n; i++ {
+			resp, err := c.GetWithRateLimit(ts.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}
+	})
+
+	// The first request goes through immediately, the following ones wait for the tightest limit.
+	offsets := append([]time.Duration{0}, ratelimittest.TickerOffsets(n-1, rate)...)
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, offsets...)
+}
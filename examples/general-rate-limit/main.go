@@ -8,11 +8,12 @@ import (
 )
 
 func main() {
-	rate := 1.0 // Rate: 1.0 executions per seconds
-	n := 2      // Number of times the function will be executed
+	rate := ratelimit.Per(1, time.Second) // Rate: 1 execution per second
+	n := 2                                // Number of times the function will be executed
 
 	// Create a rate limited worker
 	w := ratelimit.NewWorker(rate, func() { fmt.Println("Hello World!") })
+	defer w.Close()
 	fmt.Println("Rate limited worker initialized")
 
 	start := time.Now() // Start a timer to calculate the effective rate
@@ -12,10 +12,11 @@ func main() {
 	var wg sync.WaitGroup
 
 	requests := []string{"https://www.google.fr", "https://www.github.com"} // Requests
-	rate := 1.0                                                             // Rate: 1.0 query per second
+	rate := ratelimit.Per(1, time.Second)                                   // Rate: 1 query per second
 
 	// Create a rate limited HTTP client
 	c := ratelimit.NewHTTPClient(rate)
+	defer c.Close()
 	fmt.Println("Rate limited HTTP client initialized")
 
 	start := time.Now() // Start a timer to calculate the effective rate
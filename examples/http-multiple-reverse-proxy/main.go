@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/tgirier/ratelimit"
 	"github.com/tgirier/ratelimit/proxy"
 )
 
@@ -21,7 +22,10 @@ func main() {
 	// The second one illustrates how you can customize the proxy handler by setting up manually the embedded router.
 	// for this one, a tls backend will be used.
 
-	rate := 1.0 // Rate at which the request will be proxied.
+	rate, err := ratelimit.ParseRate("1/s") // Rate at which the request will be proxied.
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create multiple http backends to display proxy standard setup.
 	httpHostsNumber := 2
@@ -42,6 +46,7 @@ func main() {
 
 	// Setup the proxy handler (standard)
 	proxy := proxy.NewRateLimitedMultipleRP(rate, urls...)
+	defer proxy.Close()
 	fmt.Println("Proxy handler initialized")
 
 	// Customize the the proxy handler
@@ -9,12 +9,13 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/tgirier/ratelimit"
 	"github.com/tgirier/ratelimit/proxy"
 )
 
 func main() {
-	rate := 1.0 // Rate: 1.0 query per second
-	n := 2      // Number of requests to be sent to the proxy
+	rate := ratelimit.Per(1, time.Second) // Rate: 1 query per second
+	n := 2                                // Number of requests to be sent to the proxy
 
 	// Create a backend server for which request will be proxied at a given rate
 	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -31,6 +32,7 @@ func main() {
 
 	// Setup the proxy handler
 	proxy := proxy.NewRateLimitedSingleRP(rate, urlToProxy)
+	defer proxy.Close()
 	proxy.Server.Transport = backend.Client().Transport // Customizing the transport to ensure TLS trust to the backend
 
 	// Create a frontend server using the proxy handler
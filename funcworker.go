@@ -0,0 +1,48 @@
+package ratelimit
+
+import "context"
+
+// FuncWorker executes a function taking an argument of type T and returning a result of type R at a given rate.
+// Unlike Worker, it does not need closures or side channels to pass arguments and collect results.
+type FuncWorker[T, R any] struct {
+	// Cost is the number of limiter slots consumed by each execution.
+	// If zero, every execution consumes a single slot.
+	// A negative cost fails with ErrInvalidCost.
+	Cost int
+
+	worker
+	do func(ctx context.Context, arg T) (R, error)
+}
+
+// Do waits for a slot of the worker limiter, then executes the function with the given context and argument
+// and returns its result and error.
+// If the context ends while waiting, the function is not executed
+// and the context error is returned without consuming a slot.
+func (w *FuncWorker[T, R]) Do(ctx context.Context, arg T) (R, error) {
+	if err := w.wait(ctx, w.Cost); err != nil {
+		var zero R
+		return zero, err
+	}
+	return w.do(ctx, arg)
+}
+
+// TryDo executes the function with the given context and argument only if a slot is available now.
+// It never waits: if the worker limiter does not allow the execution, ErrRateLimited is returned immediately.
+func (w *FuncWorker[T, R]) TryDo(ctx context.Context, arg T) (R, error) {
+	if err := w.allow(w.Cost); err != nil {
+		var zero R
+		return zero, err
+	}
+	return w.do(ctx, arg)
+}
+
+// NewFuncWorker returns a worker executing the given function at the given rate, configured by the given options.
+// The limiter can be shared with other clients, workers or proxies using WithLimiter.
+// If the provided rate is zero, it defaults to the provided function.
+// The worker should be closed once it is no longer used.
+func NewFuncWorker[T, R any](rate Rate, f func(ctx context.Context, arg T) (R, error), opts ...Option) *FuncWorker[T, R] {
+	return &FuncWorker[T, R]{
+		worker: newWorker(rate, opts),
+		do:     f,
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestFuncWorkerDo(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewLimiter(2, ratelimit.WithClock(clock)), clock)
+	defer l.Close()
+
+	errOdd := errors.New("odd number")
+
+	w := ratelimit.NewFuncWorker(0, func(ctx context.Context, n int) (string, error) {
+		if n%2 != 0 {
+			return "", errOdd
+		}
+		return strconv.Itoa(n), nil
+	}, ratelimit.WithLimiter(l))
+	defer w.Close()
+
+	start := clock.Now()
+
+	clock.Run(ratelimittest.Interval(2), func() {
+		for n := 0; n < 4; n++ {
+			got, err := w.Do(context.Background(), n)
+
+			switch {
+			case n%2 != 0 && err != errOdd:
+				t.Errorf("Do(%d) returned error %v, expected %v", n, err, errOdd)
+			case n%2 == 0 && (err != nil || got != strconv.Itoa(n)):
+				t.Errorf("Do(%d) returned %q, %v, expected %q", n, got, err, strconv.Itoa(n))
+			}
+		}
+	})
+
+	ratelimittest.AssertTimes(t, l.Events(), start, ratelimittest.TickerOffsets(4, 2)...)
+}
+
+func TestFuncWorkerTryDo(t *testing.T) {
+	t.Parallel()
+
+	w := ratelimit.NewFuncWorker(0.1, func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	}, ratelimit.WithBurst(1))
+	defer w.Close()
+
+	if got, err := w.TryDo(context.Background(), 21); err != nil || got != 42 {
+		t.Fatalf("got %d, %v, expected 42", got, err)
+	}
+
+	if got, err := w.TryDo(context.Background(), 21); err != ratelimit.ErrRateLimited || got != 0 {
+		t.Fatalf("got %d, %v, expected zero and %v", got, err, ratelimit.ErrRateLimited)
+	}
+}
+
+func TestFuncWorkerClose(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(1.0, 2)
+
+	executed := false
+	w := ratelimit.NewFuncWorker(0, func(ctx context.Context, s string) (string, error) {
+		executed = true
+		return s, nil
+	}, ratelimit.WithLimiter(l))
+	w.Close()
+
+	if _, err := w.Do(context.Background(), "hello"); err != ratelimit.ErrLimiterClosed {
+		t.Fatalf("got error %v after close, expected %v", err, ratelimit.ErrLimiterClosed)
+	}
+
+	if executed {
+		t.Fatal("function executed after close")
+	}
+
+	if !l.Allow() {
+		t.Fatal("shared limiter closed by worker")
+	}
+}
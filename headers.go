@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Adaptable is implemented by limiters able to spread the remaining quota advertised by a server
+// evenly until the quota is reset.
+type Adaptable interface {
+	SetQuota(remaining int, reset time.Time)
+}
+
+// unixThreshold separates reset values given as Unix timestamps from reset values given as delays in seconds.
+const unixThreshold = 1e9
+
+// rateLimitHeaders is the set of headers advertising a server quota.
+type rateLimitHeaders struct {
+	remaining string
+	reset     string
+}
+
+// quotaHeaders are the supported quota headers, in order of preference:
+// the IETF draft headers, whose reset is a delay in seconds,
+// and the X-RateLimit headers popularized by GitHub, whose reset is usually a Unix timestamp.
+var quotaHeaders = []rateLimitHeaders{
+	{remaining: "RateLimit-Remaining", reset: "RateLimit-Reset"},
+	{remaining: "X-RateLimit-Remaining", reset: "X-RateLimit-Reset"},
+}
+
+// parseQuota returns the remaining quota and reset time advertised by the response headers.
+// It reports false if the response does not advertise a quota.
+func parseQuota(h http.Header, now time.Time) (int, time.Time, bool) {
+	for _, names := range quotaHeaders {
+		remaining, err := strconv.Atoi(strings.TrimSpace(h.Get(names.remaining)))
+		if err != nil || remaining < 0 {
+			continue
+		}
+
+		reset, err := strconv.ParseInt(strings.TrimSpace(h.Get(names.reset)), 10, 64)
+		if err != nil || reset < 0 {
+			continue
+		}
+
+		if reset >= unixThreshold {
+			return remaining, time.Unix(reset, 0), true
+		}
+		return remaining, now.Add(time.Duration(reset) * time.Second), true
+	}
+
+	return 0, time.Time{}, false
+}
+
+// adaptLimiter tunes the given limiter from the quota advertised by the response, if any.
+func adaptLimiter(l Limiter, resp *http.Response, now time.Time) {
+	a, ok := l.(Adaptable)
+	if !ok {
+		return
+	}
+
+	remaining, reset, ok := parseQuota(resp.Header, now)
+	if !ok || !reset.After(now) {
+		return
+	}
+
+	a.SetQuota(remaining, reset)
+}
@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+func TestAdaptToServerQuota(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		headers  func() http.Header
+		n        int
+		minDelay time.Duration
+		maxDelay time.Duration
+	}{
+		{
+			name: "IETF headers",
+			headers: func() http.Header {
+				h := http.Header{}
+				h.Set("RateLimit-Limit", "100")
+				h.Set("RateLimit-Remaining", "2")
+				h.Set("RateLimit-Reset", "1")
+				return h
+			},
+			// The remaining quota is spent at once from accumulated tokens, the next request waits for the reset.
+			n:        3,
+			minDelay: 900 * time.Millisecond,
+			maxDelay: 1100 * time.Millisecond,
+		},
+		{
+			name: "GitHub headers, exhausted quota",
+			headers: func() http.Header {
+				h := http.Header{}
+				h.Set("X-RateLimit-Limit", "5000")
+				h.Set("X-RateLimit-Remaining", "0")
+				h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(2*time.Second).Unix(), 10))
+				return h
+			},
+			n:        1,
+			minDelay: 500 * time.Millisecond,
+			maxDelay: 2 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range tc.headers() {
+				w.Header()[k] = v
+			}
+		}))
+		defer ts.Close()
+
+		l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10)
+
+		c := ratelimit.NewHTTPClientWithLimiter(l)
+		c.AdaptToServerQuota = true
+
+		resp, err := c.GetWithRateLimit(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		rs := make([]ratelimit.Reservation, tc.n)
+		for i := range rs {
+			rs[i] = l.Reserve()
+			defer rs[i].Cancel()
+		}
+
+		if d := rs[tc.n-1].Delay(); d < tc.minDelay || d > tc.maxDelay {
+			t.Fatalf("%s - got delay %v for request %d, expected between %v and %v", tc.name, d, tc.n, tc.minDelay, tc.maxDelay)
+		}
+	}
+}
+
+func TestAdaptToServerQuotaDisabled(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", "60")
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10)
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !l.Allow() {
+		t.Fatal("limiter tuned from headers while adaptation is disabled")
+	}
+}
@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+// DefaultIdleTimeout is the duration after which an unused host limiter is evicted.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// RequestLimiter selects the limiter applied to a request, e.g. one limiter per destination host.
+type RequestLimiter interface {
+	LimiterFor(req *http.Request) Limiter
+}
+
+// HostLimiter is a RequestLimiter keeping an independent token bucket per request host,
+// so that a slow host does not throttle traffic to unrelated hosts.
+// Host limiters are created lazily with the default rate and burst, unless a host override matches,
+// and are evicted once idle.
+// A HostLimiter must be created with NewHostLimiter.
+type HostLimiter struct {
+	mu          sync.Mutex
+	rate        Rate
+	burst       int
+	overrides   []hostOverride
+	limiters    map[string]*hostEntry
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	closed      bool
+	options     *options
+}
+
+// hostOverride sets the rate and burst of the hosts matching a pattern.
+type hostOverride struct {
+	pattern string
+	rate    Rate
+	burst   int
+}
+
+// hostEntry is the limiter of a host.
+type hostEntry struct {
+	limiter  *TokenBucket
+	lastUsed time.Time
+}
+
+// LimiterFor returns the limiter of the request host, creating it if needed.
+func (h *HostLimiter) LimiterFor(req *http.Request) Limiter {
+	return h.limiter(req.URL.Host)
+}
+
+func (h *HostLimiter) limiter(host string) Limiter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.options.clock.Now()
+	h.sweep(now)
+
+	if h.closed {
+		return failedLimiter{err: ErrLimiterClosed}
+	}
+
+	e, ok := h.limiters[host]
+	if !ok {
+		rate, burst := h.settings(host)
+		o := *h.options
+		o.burst = burst
+		e = &hostEntry{limiter: o.newBucket(rate)}
+		h.limiters[host] = e
+	}
+	e.lastUsed = now
+
+	return e.limiter
+}
+
+// settings returns the rate and burst of the given host.
+// Exact host overrides take precedence over patterns, which are tried in the order they were set.
+func (h *HostLimiter) settings(host string) (Rate, int) {
+	hostname := stripPort(host)
+
+	for _, o := range h.overrides {
+		if o.pattern == host || o.pattern == hostname {
+			return o.rate, o.burst
+		}
+	}
+
+	for _, o := range h.overrides {
+		if matchHost(o.pattern, host) || matchHost(o.pattern, hostname) {
+			return o.rate, o.burst
+		}
+	}
+
+	return h.rate, h.burst
+}
+
+// sweep evicts the host limiters idle for longer than the idle timeout.
+// Limiters with waiters or still refilling are kept so that evicting them never allows extra events.
+func (h *HostLimiter) sweep(now time.Time) {
+	if h.idleTimeout <= 0 || now.Sub(h.lastSweep) < h.idleTimeout {
+		return
+	}
+	h.lastSweep = now
+
+	for host, e := range h.limiters {
+		if now.Sub(e.lastUsed) >= h.idleTimeout && e.limiter.atRest() {
+			delete(h.limiters, host)
+		}
+	}
+}
+
+// SetHostRate sets the rate and burst of the hosts matching the given pattern.
+// Patterns are host names, optionally with a port, and may contain wildcards as defined by path.Match,
+// e.g. "*.example.com".
+// Host limiters already created are retuned.
+func (h *HostLimiter) SetHostRate(pattern string, rate Rate, burst int) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	replaced := false
+	for i, o := range h.overrides {
+		if o.pattern == pattern {
+			h.overrides[i] = hostOverride{pattern: pattern, rate: rate, burst: burst}
+			replaced = true
+		}
+	}
+	if !replaced {
+		h.overrides = append(h.overrides, hostOverride{pattern: pattern, rate: rate, burst: burst})
+	}
+
+	h.retune()
+	return nil
+}
+
+// SetRate changes the default rate of the hosts without override.
+// Host limiters already created are retuned.
+func (h *HostLimiter) SetRate(rate Rate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rate = rate
+	h.retune()
+}
+
+// SetBurst changes the default burst of the hosts without override.
+// Host limiters already created are retuned.
+func (h *HostLimiter) SetBurst(burst int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.burst = burst
+	h.retune()
+}
+
+// retune applies the current settings to the host limiters already created.
+func (h *HostLimiter) retune() {
+	for host, e := range h.limiters {
+		rate, burst := h.settings(host)
+		e.limiter.SetRate(rate)
+		e.limiter.SetBurst(burst)
+	}
+}
+
+// SetIdleTimeout changes the duration after which an unused host limiter is evicted.
+// A zero duration disables eviction.
+func (h *HostLimiter) SetIdleTimeout(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.idleTimeout = d
+}
+
+// Len returns the number of host limiters currently kept.
+func (h *HostLimiter) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.limiters)
+}
+
+// Close closes every host limiter.
+// Waiters are released with ErrLimiterClosed and subsequent requests fail fast.
+func (h *HostLimiter) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for host, e := range h.limiters {
+		e.limiter.Close()
+		delete(h.limiters, host)
+	}
+
+	return nil
+}
+
+// matchHost reports whether the host matches the pattern.
+func matchHost(pattern, host string) bool {
+	ok, err := path.Match(pattern, host)
+	return err == nil && ok
+}
+
+// stripPort returns the host without its port, if any.
+func stripPort(host string) string {
+	u := url.URL{Host: host}
+	return u.Hostname()
+}
+
+// NewHostLimiter returns a request limiter keeping an independent token bucket per request host.
+// Hosts are limited at the given rate and burst unless overridden with SetHostRate.
+// Idle host limiters are evicted after DefaultIdleTimeout.
+// Options tuning the limiter, such as WithClock or WithMaxQueue, configure every host limiter.
+func NewHostLimiter(rate Rate, burst int, opts ...Option) *HostLimiter {
+	o := newOptions(opts)
+
+	return &HostLimiter{
+		rate:        rate,
+		burst:       burst,
+		limiters:    make(map[string]*hostEntry),
+		idleTimeout: DefaultIdleTimeout,
+		lastSweep:   o.clock.Now(),
+		options:     o,
+	}
+}
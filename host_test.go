@@ -0,0 +1,228 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+var _ ratelimit.RequestLimiter = (*ratelimit.HostLimiter)(nil)
+
+func TestHTTPClientPerHost(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	})
+
+	slow := httptest.NewServer(handler)
+	defer slow.Close()
+
+	fast := httptest.NewServer(handler)
+	defer fast.Close()
+
+	h := ratelimit.NewHostLimiter(ratelimit.Per(1, time.Second), 1)
+	defer h.Close()
+
+	if err := h.SetHostRate(slow.Listener.Addr().String(), ratelimit.Per(1, time.Minute), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c := ratelimit.NewHTTPClientPerHost(h)
+	defer c.Close()
+
+	resp, err := c.TryDoWithRateLimit(mustRequest(t, slow.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err := c.TryDoWithRateLimit(mustRequest(t, slow.URL)); err != ratelimit.ErrRateLimited {
+		t.Fatalf("second request to the slow host: got %v, expected %v", err, ratelimit.ErrRateLimited)
+	}
+
+	// The slow host does not throttle the other host.
+	resp, err = c.TryDoWithRateLimit(mustRequest(t, fast.URL))
+	if err != nil {
+		t.Fatalf("request to another host: got %v, expected no error", err)
+	}
+	resp.Body.Close()
+}
+
+func TestHTTPClientPerHostBackOff(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "Retry-After", headers: map[string]string{"Retry-After": "3"}, status: http.StatusTooManyRequests},
+		{name: "exhausted quota", headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "3"}, status: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range tc.headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(tc.status)
+		}))
+		defer busy.Close()
+
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer other.Close()
+
+		// The clock never moves: a paused limiter stays paused.
+		clock := ratelimittest.NewClock(time.Now())
+
+		h := ratelimit.NewHostLimiter(ratelimit.Per(10, time.Second), 10, ratelimit.WithClock(clock))
+		defer h.Close()
+
+		c := ratelimit.New(0, ratelimit.WithHostLimiter(h), ratelimit.WithClock(clock), ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{}), ratelimit.WithServerQuota())
+		defer c.Close()
+
+		resp, err := c.GetWithRateLimit(busy.URL)
+		if err != nil {
+			t.Fatalf("%s - %v", tc.name, err)
+		}
+		resp.Body.Close()
+
+		if _, err := c.TryDoWithRateLimit(mustRequest(t, busy.URL)); err != ratelimit.ErrRateLimited {
+			t.Fatalf("%s - request to the busy host: got %v, expected %v", tc.name, err, ratelimit.ErrRateLimited)
+		}
+
+		// The busy host does not slow down the other host.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		resp, err = c.GetWithRateLimitContext(ctx, other.URL)
+		if err != nil {
+			t.Fatalf("%s - request to another host: got %v, expected no error", tc.name, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestHTTPClientPerHostDoWithReservation(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	h := ratelimit.NewHostLimiter(ratelimit.Per(1, time.Hour), 1)
+	defer h.Close()
+
+	c := ratelimit.NewHTTPClientPerHost(h)
+	defer c.Close()
+
+	req := mustRequest(t, ts.URL)
+
+	resp, err := c.DoWithReservation(req, c.Reserve(1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The reservation only holds a slot of the client limiter: the request also took the slot of its host.
+	if h.LimiterFor(req).Allow() {
+		t.Fatal("request sent on a reservation did not wait for the limiter of its host")
+	}
+}
+
+func TestHostLimiterPatterns(t *testing.T) {
+	t.Parallel()
+
+	h := ratelimit.NewHostLimiter(ratelimit.Per(1, time.Minute), 1)
+	defer h.Close()
+
+	if err := h.SetHostRate("*.example.com", ratelimit.Per(1, time.Minute), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetHostRate("api.example.com", ratelimit.Per(1, time.Minute), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetHostRate("[", 0, 1); err == nil {
+		t.Fatal("invalid pattern accepted, expected an error")
+	}
+
+	testCases := []struct {
+		name  string
+		url   string
+		burst int
+	}{
+		{name: "default", url: "http://example.org/", burst: 1},
+		{name: "wildcard", url: "http://www.example.com/", burst: 3},
+		{name: "wildcard with port", url: "http://www.example.com:8080/", burst: 3},
+		{name: "exact over wildcard", url: "http://api.example.com/", burst: 2},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			l := h.LimiterFor(mustRequest(t, tc.url))
+
+			for i := 0; i < tc.burst; i++ {
+				if !l.Allow() {
+					t.Fatalf("event %d refused, expected a burst of %d", i+1, tc.burst)
+				}
+			}
+			if l.Allow() {
+				t.Fatalf("event allowed beyond a burst of %d", tc.burst)
+			}
+		})
+	}
+}
+
+func TestHostLimiterEviction(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	h := ratelimit.NewHostLimiter(ratelimit.Per(1000, time.Second), 1, ratelimit.WithClock(clock))
+	defer h.Close()
+
+	h.SetIdleTimeout(20 * time.Millisecond)
+
+	h.LimiterFor(mustRequest(t, "http://a.example.com/"))
+	h.LimiterFor(mustRequest(t, "http://b.example.com/"))
+	if got := h.Len(); got != 2 {
+		t.Fatalf("got %d host limiters, expected 2", got)
+	}
+
+	clock.Advance(50 * time.Millisecond)
+
+	h.LimiterFor(mustRequest(t, "http://c.example.com/"))
+	if got := h.Len(); got != 1 {
+		t.Fatalf("got %d host limiters after idle timeout, expected 1", got)
+	}
+}
+
+func TestHostLimiterClose(t *testing.T) {
+	t.Parallel()
+
+	h := ratelimit.NewHostLimiter(0, 1)
+	h.Close()
+
+	if h.LimiterFor(mustRequest(t, "http://example.com/")).Allow() {
+		t.Fatal("event allowed by a closed host limiter")
+	}
+}
+
+func mustRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
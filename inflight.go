@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ErrTooManyInFlight is returned by non-blocking calls when the maximum number of requests in flight is reached.
+var ErrTooManyInFlight = errors.New("ratelimit: too many requests in flight")
+
+// ConcurrencyLimiter caps the number of requests in flight.
+// A slot is held from the time a request is sent until its response body is closed.
+type ConcurrencyLimiter interface {
+	// Acquire blocks until the request can be sent or the context is done.
+	Acquire(ctx context.Context, req *http.Request) error
+	// TryAcquire reports whether the request can be sent now and takes its slot if so.
+	// It never blocks.
+	TryAcquire(req *http.Request) bool
+	// Release gives the slot of the request back.
+	Release(req *http.Request)
+}
+
+// InFlightLimiter is a ConcurrencyLimiter capping the number of requests in flight,
+// both overall and per request host.
+// A zero maximum means no limit.
+// An InFlightLimiter must be created with NewInFlightLimiter.
+type InFlightLimiter struct {
+	mu         sync.Mutex
+	max        int
+	maxPerHost int
+	total      int
+	hosts      map[string]int
+	released   chan struct{}
+	closed     bool
+}
+
+// Acquire blocks until both the overall and the host caps allow the request or the context is done.
+// Once the limiter is closed, it fails with ErrLimiterClosed.
+func (l *InFlightLimiter) Acquire(ctx context.Context, req *http.Request) error {
+	host := req.URL.Host
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return ErrLimiterClosed
+		}
+		if l.available(host) {
+			l.take(host)
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryAcquire reports whether both the overall and the host caps allow the request now and takes its slot if so.
+func (l *InFlightLimiter) TryAcquire(req *http.Request) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	host := req.URL.Host
+	if l.closed || !l.available(host) {
+		return false
+	}
+	l.take(host)
+	return true
+}
+
+// Release gives the slot of the request back and wakes up the waiting requests.
+func (l *InFlightLimiter) Release(req *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	host := req.URL.Host
+
+	l.total--
+	l.hosts[host]--
+	if l.hosts[host] <= 0 {
+		delete(l.hosts, host)
+	}
+
+	l.broadcast()
+}
+
+// available reports whether a request to the given host can be sent.
+func (l *InFlightLimiter) available(host string) bool {
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	return l.maxPerHost <= 0 || l.hosts[host] < l.maxPerHost
+}
+
+// take records a request in flight to the given host.
+func (l *InFlightLimiter) take(host string) {
+	l.total++
+	l.hosts[host]++
+}
+
+// broadcast wakes up the waiting requests so that they check the caps again.
+func (l *InFlightLimiter) broadcast() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// SetMax changes the maximum number of requests in flight.
+// Requests already in flight are not interrupted when the maximum is lowered.
+func (l *InFlightLimiter) SetMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.max = max
+	l.broadcast()
+}
+
+// SetMaxPerHost changes the maximum number of requests in flight to a single host.
+// Requests already in flight are not interrupted when the maximum is lowered.
+func (l *InFlightLimiter) SetMaxPerHost(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxPerHost = max
+	l.broadcast()
+}
+
+// Close releases the waiting requests with ErrLimiterClosed and fails further acquisitions.
+// Requests already in flight are not interrupted and still release their slot.
+// It always returns nil.
+func (l *InFlightLimiter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		l.broadcast()
+	}
+	return nil
+}
+
+// InFlight returns the number of requests currently in flight.
+func (l *InFlightLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.total
+}
+
+// NewInFlightLimiter returns a concurrency limiter allowing up to max requests in flight,
+// of which up to maxPerHost to a single host.
+// A zero maximum means no limit.
+func NewInFlightLimiter(max, maxPerHost int) *InFlightLimiter {
+	return &InFlightLimiter{
+		max:        max,
+		maxPerHost: maxPerHost,
+		hosts:      make(map[string]int),
+		released:   make(chan struct{}),
+	}
+}
+
+// holdUntilClosed keeps a slot until the body of the response is closed.
+// If the request failed, the slot is released immediately.
+func holdUntilClosed(resp *http.Response, err error, release func()) (*http.Response, error) {
+	if err != nil || resp == nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseOnClose is a response body releasing a slot when closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes the body and releases the slot the first time it is called.
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,233 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+var _ ratelimit.ConcurrencyLimiter = (*ratelimit.InFlightLimiter)(nil)
+
+func TestHTTPClientMaxInFlight(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	c := ratelimit.NewHTTPClient(0)
+	defer c.Close()
+	c.SetMaxInFlight(1)
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slot is held until the response body is closed.
+	if _, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL)); err != ratelimit.ErrTooManyInFlight {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrTooManyInFlight)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.GetWithRateLimitContext(ctx, ts.URL); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	resp.Body.Close()
+
+	resp, err = c.TryDoWithRateLimit(mustRequest(t, ts.URL))
+	if err != nil {
+		t.Fatalf("got %v after closing the previous response body, expected no error", err)
+	}
+	resp.Body.Close()
+}
+
+func TestHTTPClientMaxInFlightPerHost(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	})
+
+	busy := httptest.NewServer(handler)
+	defer busy.Close()
+
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	c := ratelimit.NewHTTPClient(0)
+	defer c.Close()
+	c.SetMaxInFlightPerHost(1)
+
+	resp, err := c.GetWithRateLimit(busy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := c.TryDoWithRateLimit(mustRequest(t, busy.URL)); err != ratelimit.ErrTooManyInFlight {
+		t.Fatalf("request to the busy host: got %v, expected %v", err, ratelimit.ErrTooManyInFlight)
+	}
+
+	resp, err = c.TryDoWithRateLimit(mustRequest(t, other.URL))
+	if err != nil {
+		t.Fatalf("request to another host: got %v, expected no error", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTransportConcurrency(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewInFlightLimiter(1, 0)
+	c := &http.Client{Transport: &ratelimit.Transport{Concurrency: l}}
+
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := l.InFlight(); got != 1 {
+		t.Fatalf("got %d requests in flight, expected 1", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	resp.Body.Close()
+
+	if got := l.InFlight(); got != 0 {
+		t.Fatalf("got %d requests in flight after closing the response body, expected 0", got)
+	}
+}
+
+func TestHTTPClientCloseMaxInFlight(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	c := ratelimit.NewHTTPClient(0)
+	c.SetMaxInFlight(1)
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.GetWithRateLimit(ts.URL)
+		errs <- err
+	}()
+
+	// Leave the request enough time to wait for a slot.
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err := <-errs:
+		if err != ratelimit.ErrLimiterClosed {
+			t.Fatalf("got %v, expected %v", err, ratelimit.ErrLimiterClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request waiting for a slot in flight not released by Close")
+	}
+}
+
+func TestHTTPClientMaxInFlightPerHostLimiter(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	})
+
+	slow := httptest.NewServer(handler)
+	defer slow.Close()
+
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	testCases := []struct {
+		name string
+		get  func(c *ratelimit.HTTPClient, ctx context.Context, url string) (*http.Response, error)
+	}{
+		{
+			name: "RateLimit methods",
+			get: func(c *ratelimit.HTTPClient, ctx context.Context, url string) (*http.Response, error) {
+				return c.GetWithRateLimitContext(ctx, url)
+			},
+		},
+		{
+			name: "transport",
+			get: func(c *ratelimit.HTTPClient, ctx context.Context, url string) (*http.Response, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+				if err != nil {
+					return nil, err
+				}
+				return c.Do(req)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := ratelimit.NewHostLimiter(ratelimit.Per(1, time.Hour), 1)
+			defer h.Close()
+
+			c := ratelimit.New(0, ratelimit.WithHostLimiter(h), ratelimit.WithMaxInFlight(1))
+			defer c.Close()
+
+			// Exhaust the limiter of the slow host, so that the next request to it waits for an hour.
+			h.LimiterFor(mustRequest(t, slow.URL)).Allow()
+
+			queued := make(chan error, 1)
+			go func() {
+				_, err := tc.get(c, context.Background(), slow.URL)
+				queued <- err
+			}()
+			waitQueued(t, h.LimiterFor(mustRequest(t, slow.URL)).(*ratelimit.TokenBucket), 1)
+
+			// The request queued for the slow host is not in flight: it does not block other hosts.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			resp, err := tc.get(c, ctx, other.URL)
+			if err != nil {
+				t.Fatalf("request to another host: got %v, expected no error", err)
+			}
+			resp.Body.Close()
+
+			c.Close()
+			if err := <-queued; !errors.Is(err, ratelimit.ErrLimiterClosed) {
+				t.Fatalf("queued request: got %v, expected %v", err, ratelimit.ErrLimiterClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrLimiterClosed is returned when waiting on a closed limiter
+// or on a client, worker or proxy that has been closed.
+var ErrLimiterClosed = errors.New("ratelimit: limiter closed")
+
+// ErrNotTunable is returned when changing the rate or burst of a limiter that does not support it.
+var ErrNotTunable = errors.New("ratelimit: limiter is not tunable")
+
+// ErrReservationCanceled is returned when waiting on a reservation canceled before its slot was granted.
+var ErrReservationCanceled = errors.New("ratelimit: reservation canceled")
+
+// ErrRateLimited is returned by non-blocking calls when no slot is available now.
+var ErrRateLimited = errors.New("ratelimit: rate limited")
+
+// ErrQueueFull is returned when waiting on a limiter whose wait queue is full.
+var ErrQueueFull = errors.New("ratelimit: limiter queue full")
+
+// ErrWaitTimeout is returned when a waiter is not granted a slot within the maximum wait of a limiter.
+var ErrWaitTimeout = errors.New("ratelimit: limiter wait timeout")
+
+// ErrInvalidCost is returned when an event is given a negative cost.
+var ErrInvalidCost = errors.New("ratelimit: negative cost")
+
+// Limiter controls how frequently events are allowed to happen.
+// A single Limiter can be shared by several clients, workers and proxies in order to enforce a common budget.
+type Limiter interface {
+	// Wait blocks until an event is allowed or the context is done.
+	Wait(ctx context.Context) error
+	// WaitN blocks until an event costing n slots is allowed or the context is done.
+	// Events costing zero slots are allowed immediately and negative costs fail with ErrInvalidCost.
+	WaitN(ctx context.Context, n int) error
+	// Allow reports whether an event may happen now.
+	// It never blocks.
+	Allow() bool
+	// AllowN reports whether an event costing n slots may happen now.
+	// It never blocks.
+	AllowN(n int) bool
+	// Reserve returns a reservation for the next available slot.
+	// The caller must wait for the reservation before acting.
+	Reserve() Reservation
+	// ReserveN returns a reservation for an event costing n slots.
+	// The caller must wait for the reservation before acting.
+	ReserveN(n int) Reservation
+}
+
+// Reservation holds a slot reserved on a Limiter.
+type Reservation interface {
+	// OK reports whether the slot could be reserved.
+	OK() bool
+	// Delay returns the duration until the reserved slot is usable.
+	Delay() time.Duration
+	// Wait blocks until the reserved slot is usable or the context is done.
+	// The reservation is kept when the context ends first: it is up to the caller to cancel it.
+	// If the reservation is not OK, Wait returns the reason immediately.
+	Wait(ctx context.Context) error
+	// Cancel gives the reserved slot back to the limiter.
+	// It must only be called for slots that have not been acted upon.
+	Cancel()
+}
+
+// Tunable is implemented by limiters whose rate and burst can be changed at runtime.
+// Changes apply to callers already waiting on the limiter and, if it is shared, to every user of the limiter.
+type Tunable interface {
+	SetRate(rate Rate)
+	SetBurst(burst int)
+}
+
+// setRate changes the rate of the given limiter if it is tunable.
+func setRate(l Limiter, rate Rate) error {
+	t, ok := l.(Tunable)
+	if !ok {
+		return ErrNotTunable
+	}
+	t.SetRate(rate)
+	return nil
+}
+
+// setBurst changes the burst of the given limiter if it is tunable.
+func setBurst(l Limiter, burst int) error {
+	t, ok := l.(Tunable)
+	if !ok {
+		return ErrNotTunable
+	}
+	t.SetBurst(burst)
+	return nil
+}
+
+// Bounded is implemented by limiters whose wait queue can be bounded,
+// so that callers fail fast under overload instead of waiting forever.
+// Like tuning, bounding a shared limiter applies to every user of the limiter.
+type Bounded interface {
+	SetMaxQueue(n int)
+	SetMaxWait(d time.Duration)
+}
+
+// setMaxQueue bounds the queue of the given limiter if it supports it.
+func setMaxQueue(l Limiter, n int) error {
+	b, ok := l.(Bounded)
+	if !ok {
+		return ErrNotTunable
+	}
+	b.SetMaxQueue(n)
+	return nil
+}
+
+// setMaxWait bounds the wait time of the given limiter if it supports it.
+func setMaxWait(l Limiter, d time.Duration) error {
+	b, ok := l.(Bounded)
+	if !ok {
+		return ErrNotTunable
+	}
+	b.SetMaxWait(d)
+	return nil
+}
+
+// Pausable is implemented by limiters that can stop allowing events until a given time,
+// e.g. when a server asks clients to back off.
+type Pausable interface {
+	PauseUntil(t time.Time)
+}
+
+// pauseLimiter pauses the given limiter if it is pausable.
+// It reports whether the limiter could be paused.
+func pauseLimiter(l Limiter, t time.Time) bool {
+	p, ok := l.(Pausable)
+	if ok {
+		p.PauseUntil(t)
+	}
+	return ok
+}
+
+// failedLimiter is a limiter that cannot allow any event.
+type failedLimiter struct {
+	err error
+}
+
+// Wait returns the reason why the limiter fails.
+func (l failedLimiter) Wait(ctx context.Context) error {
+	return l.err
+}
+
+// WaitN returns the reason why the limiter fails.
+func (l failedLimiter) WaitN(ctx context.Context, n int) error {
+	return l.err
+}
+
+// Allow always reports false.
+func (l failedLimiter) Allow() bool {
+	return false
+}
+
+// AllowN always reports false.
+func (l failedLimiter) AllowN(n int) bool {
+	return false
+}
+
+// Reserve returns a reservation that is not OK.
+func (l failedLimiter) Reserve() Reservation {
+	return failedReservation{err: l.err}
+}
+
+// ReserveN returns a reservation that is not OK.
+func (l failedLimiter) ReserveN(n int) Reservation {
+	return failedReservation{err: l.err}
+}
+
+// failedReservation is a reservation that could not be made.
+type failedReservation struct {
+	err error
+}
+
+// OK always reports false.
+func (r failedReservation) OK() bool {
+	return false
+}
+
+// Delay always returns zero.
+func (r failedReservation) Delay() time.Duration {
+	return 0
+}
+
+// Wait returns the reason why the reservation failed.
+func (r failedReservation) Wait(ctx context.Context) error {
+	return r.err
+}
+
+// Cancel does nothing as no slot is reserved.
+func (r failedReservation) Cancel() {}
+
+// reserveN reserves n consecutive slots on the given limiter.
+func reserveN(l Limiter, n int) []Reservation {
+	rs := make([]Reservation, n)
+	for i := range rs {
+		rs[i] = l.Reserve()
+	}
+	return rs
+}
+
+// failN returns n failed reservations.
+func failN(n int, err error) []Reservation {
+	rs := make([]Reservation, n)
+	for i := range rs {
+		rs[i] = failedReservation{err: err}
+	}
+	return rs
+}
+
+// NewLimiter returns a limiter allowing events at the given rate without bursts.
+// Like a ticker, the first event is allowed after one interval.
+// If the provided rate is zero, every event is allowed.
+// Options tuning the limiter, such as WithBurst or WithClock, configure it.
+func NewLimiter(rate Rate, opts ...Option) Limiter {
+	return newOptions(opts).newBucket(rate)
+}
+
+// closeLimiter closes the given limiter if it holds resources to release.
+func closeLimiter(l Limiter) error {
+	if c, ok := l.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// waitOpen waits for n slots of the given limiter until the context is done or the done channel is closed,
+// so that callers sharing a limiter are released when the client, worker or proxy they wait for is closed.
+// Once done is closed, it fails with ErrLimiterClosed.
+// A nil done channel is never closed.
+func waitOpen(ctx context.Context, l Limiter, n int, done chan struct{}) error {
+	return untilClosed(ctx, done, func(ctx context.Context) error {
+		return l.WaitN(ctx, n)
+	})
+}
+
+// reserveOpen waits for n slots of the given limiter like waitOpen, with the priority set on the context,
+// and returns the granted reservation so that the slots can be given back if they end up unused.
+func reserveOpen(ctx context.Context, l Limiter, n int, done chan struct{}) (Reservation, error) {
+	r := reservePriority(l, n, priorityFrom(ctx))
+	if err := untilClosed(ctx, done, r.Wait); err != nil {
+		r.Cancel()
+		return nil, err
+	}
+	return r, nil
+}
+
+// untilClosed calls wait with a context ending when the done channel is closed.
+// Once done is closed, it fails with ErrLimiterClosed.
+// A nil done channel is never closed.
+func untilClosed(ctx context.Context, done chan struct{}, wait func(ctx context.Context) error) error {
+	if done == nil {
+		return wait(ctx)
+	}
+	if isClosed(done) {
+		return ErrLimiterClosed
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := wait(ctx); err != nil {
+		if isClosed(done) {
+			return ErrLimiterClosed
+		}
+		return err
+	}
+	return nil
+}
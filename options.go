@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a client created by New, a worker created by NewWorker or a limiter.
+// Options that do not apply, such as WithTransport for workers and limiters, are ignored.
+type Option func(*options)
+
+type options struct {
+	burst         int
+	clock         Clock
+	limiter       Limiter
+	hosts         RequestLimiter
+	transport     http.RoundTripper
+	maxQueue      int
+	maxWait       time.Duration
+	lifoThreshold int
+	aging         time.Duration
+	maxInFlight   int
+	maxPerHost    int
+	cost          func(req *http.Request) int
+	retry         *RetryPolicy
+	retryAfter    *RetryAfterPolicy
+	adapt         bool
+	read          Rate
+	write         Rate
+}
+
+// WithBurst makes the limiter allow bursts of up to burst events, starting full.
+// Without it, the limiter does not allow bursts and, like a ticker, the first event is allowed after one interval.
+func WithBurst(burst int) Option {
+	return func(o *options) {
+		o.burst = burst
+	}
+}
+
+// WithClock makes the limiters use the given clock instead of SystemClock,
+// e.g. a fake clock from the ratelimittest package in tests.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithLimiter makes the client or worker use the given limiter instead of creating one.
+// The limiter can be shared with other clients, workers or proxies and is left open when they are closed.
+// The rate and the options tuning the limiter, such as WithBurst, are then ignored.
+func WithLimiter(l Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithHostLimiter makes the client apply the limiter selected by the given request limiter to each request,
+// e.g. a host limiter created by NewHostLimiter, on top of the client limiter.
+func WithHostLimiter(h RequestLimiter) Option {
+	return func(o *options) {
+		o.hosts = h
+	}
+}
+
+// WithTransport makes the client send requests with the given transport instead of http.DefaultTransport.
+// Requests are still limited by the client.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = rt
+	}
+}
+
+// WithMaxQueue bounds the number of callers waiting for the limiter, see SetMaxQueue.
+func WithMaxQueue(n int) Option {
+	return func(o *options) {
+		o.maxQueue = n
+	}
+}
+
+// WithMaxWait bounds the time a caller may wait for the limiter, see SetMaxWait.
+func WithMaxWait(d time.Duration) Option {
+	return func(o *options) {
+		o.maxWait = d
+	}
+}
+
+// WithLIFOThreshold makes the limiter serve the most recent callers first
+// while more than n callers are waiting for it.
+func WithLIFOThreshold(n int) Option {
+	return func(o *options) {
+		o.lifoThreshold = n
+	}
+}
+
+// WithPriorityAging changes the waiting time after which a waiting caller is raised by one priority level,
+// DefaultPriorityAging by default.
+// A zero duration disables aging, letting higher priorities starve lower ones.
+func WithPriorityAging(d time.Duration) Option {
+	return func(o *options) {
+		o.aging = d
+	}
+}
+
+// WithMaxInFlight caps the number of requests in flight issued by the client, see SetMaxInFlight.
+func WithMaxInFlight(max int) Option {
+	return func(o *options) {
+		o.maxInFlight = max
+	}
+}
+
+// WithMaxInFlightPerHost caps the number of requests in flight issued by the client to a single host,
+// see SetMaxInFlightPerHost.
+func WithMaxInFlightPerHost(max int) Option {
+	return func(o *options) {
+		o.maxPerHost = max
+	}
+}
+
+// WithCostFunc sets the function returning the number of limiter slots consumed by each request.
+func WithCostFunc(cost func(req *http.Request) int) Option {
+	return func(o *options) {
+		o.cost = cost
+	}
+}
+
+// WithRetry makes the client retry failed requests according to the given policy.
+func WithRetry(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = &p
+	}
+}
+
+// WithRetryAfter makes the client honor the Retry-After header of 429 and 503 responses according to the given policy.
+func WithRetryAfter(p RetryAfterPolicy) Option {
+	return func(o *options) {
+		o.retryAfter = &p
+	}
+}
+
+// WithServerQuota makes the client tune its limiter from the quota advertised by servers,
+// see AdaptToServerQuota.
+func WithServerQuota() Option {
+	return func(o *options) {
+		o.adapt = true
+	}
+}
+
+// WithBandwidth limits the rate at which the client reads response bodies and sends request bodies,
+// in bytes per second, see SetBandwidth.
+func WithBandwidth(read, write Rate) Option {
+	return func(o *options) {
+		o.read = read
+		o.write = write
+	}
+}
+
+// newOptions applies the given options over the defaults.
+func newOptions(opts []Option) *options {
+	o := &options{
+		clock: SystemClock,
+		aging: DefaultPriorityAging,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// newLimiter returns the limiter set with WithLimiter, or a new token bucket allowing the given rate.
+// It reports whether the limiter was created, in which case it belongs to the caller.
+func (o *options) newLimiter(rate Rate) (Limiter, bool) {
+	if o.limiter != nil {
+		return o.limiter, false
+	}
+	return o.newBucket(rate), true
+}
+
+// newBucket returns a new token bucket allowing the given rate, configured by the options.
+func (o *options) newBucket(rate Rate) *TokenBucket {
+	burst, tokens := 1, 0
+	if o.burst > 0 {
+		burst, tokens = o.burst, o.burst
+	}
+
+	b := newTokenBucket(rate, burst, tokens, o.clock)
+
+	b.SetMaxQueue(o.maxQueue)
+	b.SetMaxWait(o.maxWait)
+	b.SetLIFOThreshold(o.lifoThreshold)
+	b.SetPriorityAging(o.aging)
+
+	return b
+}
+
+// newBandwidthLimiter returns a token bucket allowing the given bytes per second.
+func (o *options) newBandwidthLimiter(rate Rate) *TokenBucket {
+	burst := bandwidthBurst(rate)
+	return newTokenBucket(rate, burst, burst, o.clock)
+}
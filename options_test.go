@@ -0,0 +1,112 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+// roundTripperFunc is an http.RoundTripper calling a function.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	var sent int32
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sent, 1)
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	var c *ratelimit.HTTPClient = ratelimit.New(ratelimit.Per(1, time.Minute),
+		ratelimit.WithBurst(3),
+		ratelimit.WithTransport(base),
+		ratelimit.WithMaxInFlight(2),
+		ratelimit.WithCostFunc(func(req *http.Request) int { return 1 }),
+	)
+	defer c.Close()
+
+	var resps []*http.Response
+	for i := 0; i < 2; i++ {
+		resp, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL))
+		if err != nil {
+			t.Fatalf("request %d: got %v, expected the burst to allow it", i+1, err)
+		}
+		resps = append(resps, resp)
+	}
+
+	if _, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL)); err != ratelimit.ErrTooManyInFlight {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrTooManyInFlight)
+	}
+
+	for _, resp := range resps {
+		resp.Body.Close()
+	}
+
+	resp, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL))
+	if err != nil {
+		t.Fatalf("got %v, expected the burst to allow a third request", err)
+	}
+	resp.Body.Close()
+
+	if _, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL)); err != ratelimit.ErrRateLimited {
+		t.Fatalf("got %v, expected %v once the burst is consumed", err, ratelimit.ErrRateLimited)
+	}
+
+	if got := atomic.LoadInt32(&sent); got != 3 {
+		t.Fatalf("%d requests sent with the provided transport, expected 3", got)
+	}
+}
+
+func TestNewWorkerWithOptions(t *testing.T) {
+	t.Parallel()
+
+	var w *ratelimit.Worker = ratelimit.NewWorker(ratelimit.Per(1, time.Minute), func() {},
+		ratelimit.WithBurst(2),
+		ratelimit.WithMaxWait(time.Second),
+	)
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := w.TryDoWithRateLimit(); err != nil {
+			t.Fatalf("execution %d: got %v, expected the burst to allow it", i+1, err)
+		}
+	}
+
+	if err := w.DoWithRateLimitContext(context.Background()); err != ratelimit.ErrWaitTimeout {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrWaitTimeout)
+	}
+}
+
+func TestNewWithSharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1)
+	defer l.Close()
+
+	// Options tuning the limiter are ignored when it is shared.
+	c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithBurst(10))
+	c.Close()
+
+	if !l.Allow() {
+		t.Fatal("shared limiter closed with the client, expected it to be left open")
+	}
+	if l.Allow() {
+		t.Fatal("event allowed beyond the shared limiter burst")
+	}
+}
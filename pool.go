@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool executes jobs concurrently with a function taking an argument of type T and returning a result of type R.
+// Up to a given number of jobs run at the same time, and the rate of the pool limiter is enforced across all of them.
+// It embeds the FuncWorker executing each job, so that jobs can also be executed one by one with Do.
+type Pool[T, R any] struct {
+	*FuncWorker[T, R]
+
+	// Ordered streams results in submission order instead of completion order.
+	// A job finishing early keeps its place in the pool until the jobs submitted before it are streamed.
+	Ordered bool
+
+	size int
+}
+
+// Result is the outcome of a job run by a Pool.
+type Result[T, R any] struct {
+	// Index is the position of the job in submission order, starting at zero.
+	Index int
+	// Job is the argument the job was executed with.
+	Job T
+	// Value is the result returned by the function.
+	Value R
+	// Err is the error returned by the function, or the reason why it was not executed,
+	// e.g. the context ended while the job was waiting for the limiter.
+	Err error
+}
+
+// poolJob is a job submitted to a pool, with its position in submission order.
+type poolJob[T any] struct {
+	index int
+	arg   T
+}
+
+// Run executes the jobs received from the given channel until it is closed or the context is done,
+// and streams their results on the returned channel, closed once every started job has completed.
+// The returned channel must be drained: jobs are not started while their results are not consumed.
+func (p *Pool[T, R]) Run(ctx context.Context, jobs <-chan T) <-chan Result[T, R] {
+	// slots bounds the number of jobs started and not yet streamed.
+	slots := make(chan struct{}, p.size)
+	done := make(chan Result[T, R])
+	results := make(chan Result[T, R])
+
+	go p.dispatch(ctx, jobs, slots, done)
+	go p.stream(done, slots, results)
+
+	return results
+}
+
+// RunSlice executes the given jobs until they are all started or the context is done,
+// and streams their results on the returned channel, closed once every started job has completed.
+// The returned channel must be drained: jobs are not started while their results are not consumed.
+func (p *Pool[T, R]) RunSlice(ctx context.Context, jobs []T) <-chan Result[T, R] {
+	ch := make(chan T)
+
+	go func() {
+		defer close(ch)
+
+		for _, job := range jobs {
+			select {
+			case ch <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return p.Run(ctx, ch)
+}
+
+// dispatch starts a job in its own goroutine for each job received, as long as a slot is available.
+// It closes done once no job remains to be started and every started job has completed.
+func (p *Pool[T, R]) dispatch(ctx context.Context, jobs <-chan T, slots chan struct{}, done chan<- Result[T, R]) {
+	var wg sync.WaitGroup
+
+	defer func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for i := 0; ; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		var job poolJob[T]
+
+		select {
+		case arg, ok := <-jobs:
+			if !ok {
+				return
+			}
+			job = poolJob[T]{index: i, arg: arg}
+		case <-ctx.Done():
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			v, err := p.Do(ctx, job.arg)
+			done <- Result[T, R]{Index: job.index, Job: job.arg, Value: v, Err: err}
+		}()
+	}
+}
+
+// stream forwards the results of completed jobs in the order of the pool,
+// giving back the slot of each job once its result has been consumed.
+func (p *Pool[T, R]) stream(done <-chan Result[T, R], slots <-chan struct{}, results chan<- Result[T, R]) {
+	defer close(results)
+
+	pending := make(map[int]Result[T, R])
+	next := 0
+
+	for r := range done {
+		if !p.Ordered {
+			results <- r
+			<-slots
+			continue
+		}
+
+		pending[r.Index] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			results <- r
+			<-slots
+		}
+	}
+}
+
+// NewPool returns a pool running up to size jobs at the same time with the given function at the given rate,
+// configured by the given options.
+// The limiter can be shared with other clients, workers or proxies using WithLimiter.
+// If the provided rate is zero, jobs are only limited by the size of the pool.
+// If the provided size is lower than one, jobs run one at a time.
+// The pool should be closed once it is no longer used.
+func NewPool[T, R any](rate Rate, size int, f func(ctx context.Context, arg T) (R, error), opts ...Option) *Pool[T, R] {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool[T, R]{
+		FuncWorker: NewFuncWorker(rate, f, opts...),
+		size:       size,
+	}
+}
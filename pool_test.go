@@ -0,0 +1,160 @@
+package ratelimit_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestPoolRate(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewLimiter(2, ratelimit.WithClock(clock)), clock)
+	defer l.Close()
+
+	p := ratelimit.NewPool(0, 3, func(ctx context.Context, n int) (int, error) {
+		return n * n, nil
+	}, ratelimit.WithLimiter(l))
+	defer p.Close()
+
+	start := clock.Now()
+	jobs := []int{0, 1, 2, 3, 4, 5}
+
+	squares := make([]int, len(jobs))
+	clock.Run(ratelimittest.Interval(2), func() {
+		for r := range p.RunSlice(context.Background(), jobs) {
+			if r.Err != nil {
+				t.Errorf("job %d failed: %v", r.Index, r.Err)
+			}
+			squares[r.Index] = r.Value
+		}
+	})
+
+	if expected := []int{0, 1, 4, 9, 16, 25}; !reflect.DeepEqual(squares, expected) {
+		t.Fatalf("got results %v, expected %v", squares, expected)
+	}
+
+	ratelimittest.AssertTimes(t, l.Events(), start, ratelimittest.TickerOffsets(len(jobs), 2)...)
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	t.Parallel()
+
+	size := 2
+
+	var mu sync.Mutex
+	var running, maxRunning int
+
+	p := ratelimit.NewPool(0, size, func(ctx context.Context, n int) (int, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return n, nil
+	})
+	defer p.Close()
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < 10; i++ {
+			jobs <- i
+		}
+	}()
+
+	n := 0
+	for range p.Run(context.Background(), jobs) {
+		n++
+	}
+
+	if n != 10 {
+		t.Fatalf("got %d results, expected 10", n)
+	}
+	if maxRunning != size {
+		t.Fatalf("got up to %d jobs running at the same time, expected %d", maxRunning, size)
+	}
+}
+
+func TestPoolOrder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		ordered  bool
+		expected []int
+	}{
+		{name: "completion order", ordered: false, expected: []int{2, 0, 1}},
+		{name: "submission order", ordered: true, expected: []int{0, 1, 2}},
+	}
+
+	for _, tc := range testCases {
+		// Each job completes once its gate is opened.
+		gates := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+		started := make(chan struct{}, len(gates))
+
+		p := ratelimit.NewPool(0, len(gates), func(ctx context.Context, n int) (int, error) {
+			started <- struct{}{}
+			<-gates[n]
+			return n, nil
+		})
+		defer p.Close()
+		p.Ordered = tc.ordered
+
+		results := p.RunSlice(context.Background(), []int{0, 1, 2})
+
+		for range gates {
+			<-started
+		}
+
+		var got []int
+		for _, i := range []int{2, 0, 1} {
+			close(gates[i])
+			if !tc.ordered {
+				got = append(got, (<-results).Value)
+			}
+		}
+		for r := range results {
+			got = append(got, r.Value)
+		}
+
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s - got results %v, expected %v", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestPoolContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	p := ratelimit.NewPool(0.1, 2, func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := make(chan int)
+
+	results := p.Run(ctx, jobs)
+	jobs <- 1
+	cancel()
+
+	for r := range results {
+		if r.Err != context.Canceled {
+			t.Fatalf("got error %v, expected %v", r.Err, context.Canceled)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Priority orders the callers waiting on a limiter: when several callers are waiting,
+// those with a higher priority are granted the next slots first.
+// Callers with the same priority are served in order of arrival.
+type Priority int
+
+// Predefined priorities. Any other value can be used to define finer classes.
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// DefaultPriorityAging is the waiting time after which a queued caller is raised by one priority level.
+// It guarantees that low priority callers are never starved by a steady flow of higher priority ones:
+// a caller waits at most one aging period per priority level below the others before being served first,
+// while short waits are still ordered by priority.
+const DefaultPriorityAging = 10 * time.Second
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of the context making callers waiting with it on a limiter use the given priority,
+// e.g. to serve interactive requests before background batch jobs sharing the same client.
+// Without priority, callers wait with PriorityNormal.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority set on the context.
+func priorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// prioritized is implemented by limiters supporting priorities.
+type prioritized interface {
+	reservePriority(n int, p Priority) Reservation
+}
+
+// reservePriority reserves n slots on the given limiter with the given priority.
+// Priorities are ignored by limiters not supporting them.
+func reservePriority(l Limiter, n int, p Priority) Reservation {
+	if pl, ok := l.(prioritized); ok {
+		return pl.reservePriority(n, p)
+	}
+	return l.ReserveN(n)
+}
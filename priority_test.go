@@ -0,0 +1,286 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		limiter func(b ratelimit.Limiter) ratelimit.Limiter
+	}{
+		{name: "token bucket", limiter: func(b ratelimit.Limiter) ratelimit.Limiter { return b }},
+		{name: "multi limiter", limiter: func(b ratelimit.Limiter) ratelimit.Limiter { return ratelimit.NewMultiLimiter(b) }},
+	}
+
+	priorities := []ratelimit.Priority{
+		ratelimit.PriorityLow,
+		ratelimit.PriorityNormal,
+		ratelimit.PriorityLow,
+		ratelimit.PriorityHigh,
+		ratelimit.PriorityNormal,
+		ratelimit.PriorityHigh,
+	}
+	expected := []int{3, 5, 1, 4, 0, 2}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), len(priorities))
+			defer b.Close()
+
+			b.SetPriorityAging(0)
+			l := tc.limiter(b)
+
+			// Hold every token so that waiters are granted one at a time, when a held token is given back.
+			held := make([]ratelimit.Reservation, len(priorities))
+			for i := range held {
+				held[i] = b.Reserve()
+			}
+
+			granted := make(chan int)
+			for i, p := range priorities {
+				go func(id int, p ratelimit.Priority) {
+					if err := l.Wait(ratelimit.WithPriority(context.Background(), p)); err == nil {
+						granted <- id
+					}
+				}(i, p)
+
+				waitQueued(t, b, i+1)
+			}
+
+			for i, r := range held {
+				r.Cancel()
+
+				select {
+				case id := <-granted:
+					if id != expected[i] {
+						t.Fatalf("slot %d granted to waiter %d, expected waiter %d", i, id, expected[i])
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("slot %d not granted", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPriorityAging(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		waited   time.Duration
+		late     ratelimit.Priority
+		expected string
+	}{
+		{name: "not raised within an aging period", waited: 900 * time.Millisecond, late: ratelimit.PriorityNormal, expected: "late"},
+		{name: "raised to the next priority", waited: time.Second, late: ratelimit.PriorityNormal, expected: "low"},
+		{name: "raised once per aging period", waited: time.Second, late: ratelimit.PriorityHigh, expected: "late"},
+		{name: "raised above higher priorities", waited: 2 * time.Second, late: ratelimit.PriorityHigh, expected: "low"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratelimittest.NewClock(time.Now())
+			b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1, ratelimit.WithClock(clock))
+			defer b.Close()
+
+			b.SetPriorityAging(time.Second)
+			held := b.Reserve()
+
+			granted := make(chan string, 2)
+			go func() {
+				if err := b.Wait(ratelimit.WithPriority(context.Background(), ratelimit.PriorityLow)); err == nil {
+					granted <- "low"
+				}
+			}()
+			waitQueued(t, b, 1)
+
+			// The low priority waiter waits before the late one arrives.
+			clock.Advance(tc.waited)
+
+			go func() {
+				if err := b.Wait(ratelimit.WithPriority(context.Background(), tc.late)); err == nil {
+					granted <- "late"
+				}
+			}()
+			waitQueued(t, b, 2)
+
+			held.Cancel()
+
+			select {
+			case got := <-granted:
+				if got != tc.expected {
+					t.Fatalf("slot granted to the %s waiter, expected the %s waiter", got, tc.expected)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("slot not granted")
+			}
+		})
+	}
+}
+
+func TestPriorityAgingOrder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		priority  ratelimit.Priority
+		threshold int
+		waiters   int
+		late      ratelimit.Priority
+		expected  []int
+	}{
+		{
+			name:     "priorities apply within an aging period",
+			priority: ratelimit.PriorityLow, waiters: 10, late: ratelimit.PriorityHigh,
+			expected: []int{0, 1, 10, 2, 3},
+		},
+		{
+			name:     "lifo among waiters of the same priority",
+			priority: ratelimit.PriorityNormal, threshold: 2, waiters: 4, late: ratelimit.PriorityNormal,
+			expected: []int{3, 2, 4, 0, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratelimittest.NewClock(time.Now())
+			b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Second), 1, ratelimit.WithClock(clock))
+			defer b.Close()
+
+			b.SetLIFOThreshold(tc.threshold)
+			b.Allow()
+
+			granted := make(chan int, tc.waiters+1)
+			wait := func(id int, p ratelimit.Priority) {
+				if err := b.Wait(ratelimit.WithPriority(context.Background(), p)); err == nil {
+					granted <- id
+				}
+			}
+
+			for i := 0; i < tc.waiters; i++ {
+				go wait(i, tc.priority)
+				waitQueued(t, b, i+1)
+			}
+
+			// The late waiter arrives after two of the first ones were served.
+			var got []int
+			for i := 0; i < 2; i++ {
+				clock.Advance(time.Second)
+				got = append(got, receive(t, granted))
+			}
+			clock.Advance(500 * time.Millisecond)
+
+			go wait(tc.waiters, tc.late)
+			waitQueued(t, b, tc.waiters-1)
+
+			clock.Advance(500 * time.Millisecond)
+			got = append(got, receive(t, granted))
+			for len(got) < len(tc.expected) {
+				clock.Advance(time.Second)
+				got = append(got, receive(t, granted))
+			}
+
+			for i, id := range got {
+				if id != tc.expected[i] {
+					t.Fatalf("slots granted to waiters %v, expected %v", got, tc.expected)
+				}
+			}
+		})
+	}
+}
+
+// receive returns the next waiter granted a slot.
+func receive(t *testing.T, granted <-chan int) int {
+	t.Helper()
+
+	select {
+	case id := <-granted:
+		return id
+	case <-time.After(time.Second):
+		t.Fatal("slot not granted")
+		return 0
+	}
+}
+
+func TestPriorityAgingStarvation(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	b := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Second), 1, ratelimit.WithClock(clock))
+	defer b.Close()
+
+	b.Allow()
+
+	granted := make(chan ratelimit.Priority, 1)
+	wait := func(p ratelimit.Priority) {
+		if err := b.Wait(ratelimit.WithPriority(context.Background(), p)); err == nil {
+			granted <- p
+		}
+	}
+
+	go wait(ratelimit.PriorityLow)
+	waitQueued(t, b, 1)
+
+	// A new high priority waiter arrives for every slot, yet the low priority one is served
+	// once it waited for an aging period per priority level separating it from them.
+	bound := 2 * ratelimit.DefaultPriorityAging
+	start := clock.Now()
+	for clock.Now().Sub(start) < bound {
+		go wait(ratelimit.PriorityHigh)
+		waitQueued(t, b, 2)
+
+		clock.Advance(time.Second)
+		if p := <-granted; p == ratelimit.PriorityLow {
+			return
+		}
+	}
+	t.Fatalf("low priority waiter not served within %v of continuous high priority load", bound)
+}
+
+func TestWorkerPriority(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+	defer l.Close()
+
+	held := l.Reserve()
+
+	executed := make(chan string, 2)
+	low := ratelimit.NewWorkerWithLimiter(l, func() { executed <- "batch" })
+	high := ratelimit.NewWorkerWithLimiter(l, func() { executed <- "interactive" })
+
+	go low.DoWithRateLimitContext(ratelimit.WithPriority(context.Background(), ratelimit.PriorityLow))
+	waitQueued(t, l, 1)
+
+	go high.DoWithRateLimitContext(ratelimit.WithPriority(context.Background(), ratelimit.PriorityHigh))
+	waitQueued(t, l, 2)
+
+	held.Cancel()
+
+	select {
+	case got := <-executed:
+		if got != "interactive" {
+			t.Fatalf("%s function executed first, expected the interactive one", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no function executed")
+	}
+}
@@ -2,43 +2,57 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"time"
+	"sync"
+
+	"github.com/tgirier/ratelimit"
 )
 
 // rateLimitedSingleRP is an http proxy that rate limits outgoing requests for a single host.
 // If the provided rate is zero, it defaults to a plain http reverse proxy.
 type rateLimitedSingleRP struct {
 	Server httputil.ReverseProxy
-	ticker *time.Ticker
+	limited
 }
 
 // ServeHTTP is an http handler.
-// It listens to incoming requests, waits for an available ticker and sends the request back to the initial caller.
+// It listens to incoming requests, waits for an available slot and sends the request back to the initial caller.
+// If the incoming request is canceled while waiting or the proxy is closed, it replies with a service unavailable error.
 func (p *rateLimitedSingleRP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if p.ticker != nil {
-		<-p.ticker.C
+	if err := p.wait(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 	p.Server.ServeHTTP(w, r)
 }
 
 // NewRateLimitedSingleRP returns a rate limited http proxy for the given URL.
-func NewRateLimitedSingleRP(rate float64, target *url.URL) *rateLimitedSingleRP {
-	rp := httputil.NewSingleHostReverseProxy(target)
+// The proxy should be closed once it is no longer used.
+func NewRateLimitedSingleRP(rate ratelimit.Rate, target *url.URL) *rateLimitedSingleRP {
+	p := NewRateLimitedSingleRPWithLimiter(ratelimit.NewLimiter(rate), target)
+	p.ownLimiter = true
+	return p
+}
 
-	p := &rateLimitedSingleRP{
-		Server: *rp,
+// NewRateLimitedSingleRPWithLimiter returns an http proxy for the given URL rate limited by the given limiter.
+// The limiter can be shared with other proxies, clients or workers.
+// If the provided limiter is nil, it defaults to a plain http reverse proxy.
+func NewRateLimitedSingleRPWithLimiter(l ratelimit.Limiter, target *url.URL) *rateLimitedSingleRP {
+	if l == nil {
+		l = ratelimit.NewLimiter(0)
 	}
 
-	if rate != 0.0 {
-		tickInterval := time.Duration(1e9/rate) * time.Nanosecond
-		p.ticker = time.NewTicker(tickInterval)
-	}
+	rp := httputil.NewSingleHostReverseProxy(target)
 
-	return p
+	return &rateLimitedSingleRP{
+		Server:  *rp,
+		limited: newLimited(l),
+	}
 }
 
 // rateLimitedMultipleRP is an http reverse proxy that rate limits outgoing requests for multiple hosts.
@@ -46,25 +60,38 @@ func NewRateLimitedSingleRP(rate float64, target *url.URL) *rateLimitedSingleRP
 // If the provided rate is zero, it defaults to a plain http reverse proxy.
 type rateLimitedMultipleRP struct {
 	Router *http.ServeMux
-	ticker *time.Ticker
+	limited
 }
 
 // ServeHTTP is an http handler.
 // It listens to incoming resquests and passes it to the embedded router at a given rate.
+// If the incoming request is canceled while waiting or the proxy is closed, it replies with a service unavailable error.
 func (mp *rateLimitedMultipleRP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if mp.ticker != nil {
-		<-mp.ticker.C
+	if err := mp.wait(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 	mp.Router.ServeHTTP(w, r)
 }
 
 // NewRateLimitedMultipleRP returns a multiple host rate limited reverse proxy.
-func NewRateLimitedMultipleRP(rate float64, targets ...*url.URL) *rateLimitedMultipleRP {
-	mp := &rateLimitedMultipleRP{}
+// The proxy should be closed once it is no longer used.
+func NewRateLimitedMultipleRP(rate ratelimit.Rate, targets ...*url.URL) *rateLimitedMultipleRP {
+	mp := NewRateLimitedMultipleRPWithLimiter(ratelimit.NewLimiter(rate), targets...)
+	mp.ownLimiter = true
+	return mp
+}
+
+// NewRateLimitedMultipleRPWithLimiter returns a multiple host reverse proxy rate limited by the given limiter.
+// The limiter can be shared with other proxies, clients or workers.
+// If the provided limiter is nil, it defaults to a plain http reverse proxy.
+func NewRateLimitedMultipleRPWithLimiter(l ratelimit.Limiter, targets ...*url.URL) *rateLimitedMultipleRP {
+	if l == nil {
+		l = ratelimit.NewLimiter(0)
+	}
 
-	if rate != 0.0 {
-		tickerInterval := time.Duration(1e9/rate) * time.Nanosecond
-		mp.ticker = time.NewTicker(tickerInterval)
+	mp := &rateLimitedMultipleRP{
+		limited: newLimited(l),
 	}
 
 	mp.Router = http.NewServeMux()
@@ -77,3 +104,88 @@ func NewRateLimitedMultipleRP(rate float64, targets ...*url.URL) *rateLimitedMul
 
 	return mp
 }
+
+// limited holds the limiter shared by the requests of a proxy.
+type limited struct {
+	limiter    ratelimit.Limiter
+	ownLimiter bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// wait blocks until the proxy limiter allows a request, the context is done or the proxy is closed.
+// Once the proxy is closed, it fails with ratelimit.ErrLimiterClosed,
+// even if the limiter is shared and left open.
+func (l *limited) wait(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return ratelimit.ErrLimiterClosed
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := l.limiter.Wait(ctx); err != nil {
+		select {
+		case <-l.done:
+			return ratelimit.ErrLimiterClosed
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRate changes the rate of the proxy limiter while requests may be waiting.
+// If the limiter is not tunable, ratelimit.ErrNotTunable is returned.
+func (l *limited) SetRate(rate ratelimit.Rate) error {
+	t, ok := l.limiter.(ratelimit.Tunable)
+	if !ok {
+		return ratelimit.ErrNotTunable
+	}
+	t.SetRate(rate)
+	return nil
+}
+
+// SetBurst changes the burst of the proxy limiter while requests may be waiting.
+// If the limiter is not tunable, ratelimit.ErrNotTunable is returned.
+func (l *limited) SetBurst(burst int) error {
+	t, ok := l.limiter.(ratelimit.Tunable)
+	if !ok {
+		return ratelimit.ErrNotTunable
+	}
+	t.SetBurst(burst)
+	return nil
+}
+
+// Close stops the proxy limiter.
+// Requests waiting for the limiter and subsequent requests are rejected with a service unavailable error.
+// A limiter provided to a WithLimiter constructor is left open as it may be shared.
+func (l *limited) Close() error {
+	var err error
+
+	l.closeOnce.Do(func() {
+		close(l.done)
+		if c, ok := l.limiter.(io.Closer); ok && l.ownLimiter {
+			err = c.Close()
+		}
+	})
+
+	return err
+}
+
+func newLimited(l ratelimit.Limiter) limited {
+	return limited{
+		limiter: l,
+		done:    make(chan struct{}),
+	}
+}
@@ -9,78 +9,58 @@ import (
 	"testing"
 	"time"
 
+	"github.com/tgirier/ratelimit"
 	"github.com/tgirier/ratelimit/proxy"
+	"github.com/tgirier/ratelimit/ratelimittest"
 )
 
 func TestServeHTTPSingleWithRateLimit(t *testing.T) {
 	t.Parallel()
 
 	want := "Request forwarded by proxy"
-	noLimitThreshold := 100.0
-	errorMargin := 0.05
 
 	testCases := []struct {
 		name   string
 		number int
-		rate   float64
+		rate   ratelimit.Rate
 	}{
 		{name: "2 requests, 1 QPS", number: 2, rate: 1.0},
 		{name: "2 requests, no rate limiting", number: 2, rate: 0.0},
 	}
 
-	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		fmt.Fprintf(w, want)
-	}))
-	defer ts.Close()
+	for _, tc := range testCases {
+		clock := ratelimittest.NewClock(time.Now())
 
-	rpURL, err := url.Parse(ts.URL)
-	if err != nil {
-		t.Fatal(err)
-	}
+		ts := ratelimittest.NewTLSServer(clock, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, want)
+		}))
+		defer ts.Close()
 
-	for _, tc := range testCases {
-		rp := proxy.NewRateLimitedSingleRP(tc.rate, rpURL)
+		rpURL, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := ratelimit.NewLimiter(tc.rate, ratelimit.WithClock(clock))
+
+		rp := proxy.NewRateLimitedSingleRPWithLimiter(l, rpURL)
+		defer rp.Close()
 		rp.Server.Transport = ts.Client().Transport
 
 		p := httptest.NewTLSServer(rp)
 		defer p.Close()
 
-		start := time.Now()
+		start := clock.Now()
 
-		for i := 0; i < tc.number; i++ {
-			resp, err := p.Client().Get(p.URL)
-			if err != nil {
-				t.Fatal(err)
+		clock.Run(ratelimittest.Interval(tc.rate), func() {
+			for i := 0; i < tc.number; i++ {
+				if b := get(t, p, "/"); b != want {
+					t.Errorf("%s - got: %s, expected: %s", tc.name, b, want)
+				}
 			}
-			defer resp.Body.Close()
+		})
 
-			b, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			s := string(b)
-
-			if s != want {
-				t.Fatalf("%s - got: %s, expected: %s", tc.name, s, want)
-			}
-		}
-
-		stop := time.Now()
-		duration := stop.Sub(start).Seconds()
-		effectiveRate := float64(tc.number) / duration
-
-		if tc.rate == 0.0 && effectiveRate < noLimitThreshold {
-			t.Fatalf("%s - effective rate %f, no rate limiting threshold %.2f", tc.name, effectiveRate, noLimitThreshold)
-		}
-
-		if tc.rate != 0.0 && effectiveRate > tc.rate {
-			t.Fatalf("%s - effective rate too high %f, expected %.2f", tc.name, effectiveRate, tc.rate)
-		}
-
-		if tc.rate != 0.0 && effectiveRate < (tc.rate-errorMargin) {
-			t.Fatalf("%s - effective rate too low %f, expected %.2f, error margin %f", tc.name, effectiveRate, tc.rate, errorMargin)
-		}
+		ratelimittest.AssertTimes(t, ts.Arrivals(), start, ratelimittest.TickerOffsets(tc.number, tc.rate)...)
 	}
 }
 
@@ -88,85 +68,219 @@ func TestServeHTTPMultipleWithRateLimit(t *testing.T) {
 	t.Parallel()
 
 	want := "Hello from srv "
-	noLimitThreshold := 100.0
-	errorMargin := 0.05
-
-	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, want, r.URL.Path[1:])
-	}))
-	defer ts.Close()
 
 	testCases := []struct {
 		name       string
 		hostNumber int
-		rate       float64
+		rate       ratelimit.Rate
 	}{
 		{name: "2 hosts, 1 QPS", hostNumber: 2, rate: 1.0},
 		{name: "3 hosts, no rate limiting", hostNumber: 3, rate: 0.0},
 	}
 
 	for _, tc := range testCases {
-		urls, srvs, err := startMultipleTestServers(tc.hostNumber, want)
+		clock := ratelimittest.NewClock(time.Now())
+
+		urls, srvs, err := startMultipleTestServers(clock, tc.hostNumber, want)
 		if err != nil {
 			closeMultipleSrvs(srvs)
 			t.Fatal(err)
 		}
 		defer closeMultipleSrvs(srvs)
 
-		multipleRP := proxy.NewRateLimitedMultipleRP(tc.rate, urls...)
+		l := ratelimit.NewLimiter(tc.rate, ratelimit.WithClock(clock))
+
+		multipleRP := proxy.NewRateLimitedMultipleRPWithLimiter(l, urls...)
+		defer multipleRP.Close()
 
 		p := httptest.NewTLSServer(multipleRP)
 		defer p.Close()
 
-		start := time.Now()
+		start := clock.Now()
 
-		for _, url := range urls {
-			proxyURL := p.URL + "/" + url.Host
-
-			resp, err := p.Client().Get(proxyURL)
-			if err != nil {
-				t.Fatal(err)
+		clock.Run(ratelimittest.Interval(tc.rate), func() {
+			for _, url := range urls {
+				if b, expected := get(t, p, "/"+url.Host), want+url.Host; b != expected {
+					t.Errorf("%s - got: %s, expected: %s", tc.name, b, expected)
+				}
 			}
-			defer resp.Body.Close()
+		})
 
-			b, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
+		// Hosts are requested one after the other, so their arrivals are in order.
+		var arrivals []ratelimittest.Event
+		for _, srv := range srvs {
+			arrivals = append(arrivals, srv.Arrivals()...)
+		}
 
-			s := string(b)
-			expected := want + url.Host
+		ratelimittest.AssertTimes(t, arrivals, start, ratelimittest.TickerOffsets(tc.hostNumber, tc.rate)...)
+	}
+}
 
-			if s != expected {
-				t.Fatalf("%s - got: %s, expected: %s", tc.name, s, expected)
-			}
+func TestServeHTTPAfterClose(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "Request forwarded by proxy")
+	}))
+	defer ts.Close()
+
+	rpURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		handler interface {
+			http.Handler
+			Close() error
+		}
+		path string
+	}{
+		{name: "single host proxy", handler: proxy.NewRateLimitedSingleRP(1.0, rpURL), path: "/"},
+		{name: "multiple hosts proxy", handler: proxy.NewRateLimitedMultipleRP(1.0, rpURL), path: "/" + rpURL.Host},
+	}
+
+	for _, tc := range testCases {
+		p := httptest.NewServer(tc.handler)
+		defer p.Close()
+
+		if err := tc.handler.Close(); err != nil {
+			t.Fatal(err)
 		}
 
-		stop := time.Now()
-		duration := stop.Sub(start).Seconds()
-		effectiveRate := float64(len(urls)) / duration
+		resp, err := p.Client().Get(p.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
 
-		if tc.rate == 0.0 && effectiveRate < noLimitThreshold {
-			t.Fatalf("%s - effective rate %f, no rate limiting threshold %.2f", tc.name, effectiveRate, noLimitThreshold)
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("%s - got status %d, expected %d", tc.name, resp.StatusCode, http.StatusServiceUnavailable)
 		}
+	}
+}
 
-		if tc.rate != 0.0 && effectiveRate > tc.rate {
-			t.Fatalf("%s - effective rate too high %f, expected %.2f", tc.name, effectiveRate, tc.rate)
+func TestServeHTTPCloseSharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "Request forwarded by proxy")
+	}))
+	defer ts.Close()
+
+	rpURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+	l.Allow()
+
+	rp := proxy.NewRateLimitedSingleRPWithLimiter(l, rpURL)
+
+	p := httptest.NewServer(rp)
+	defer p.Close()
+	// Closing the shared limiter before the server releases a request left waiting on failure.
+	defer l.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := p.Client().Get(p.URL)
+		if err != nil {
+			t.Error(err)
+			status <- 0
+			return
 		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for l.Waiting() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("request not waiting for the shared limiter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rp.Close()
+
+	select {
+	case got := <-status:
+		if got != http.StatusServiceUnavailable {
+			t.Fatalf("got status %d, expected %d", got, http.StatusServiceUnavailable)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting request not released by Close")
+	}
+}
+
+func TestServeHTTPSetRate(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "Request forwarded by proxy")
+	}))
+	defer ts.Close()
+
+	rpURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 5
+	rate := ratelimit.Per(100, time.Second)
+
+	rp := proxy.NewRateLimitedSingleRPWithLimiter(ratelimit.NewLimiter(0.1, ratelimit.WithClock(clock)), rpURL)
+	defer rp.Close()
 
-		if tc.rate != 0.0 && effectiveRate < (tc.rate-errorMargin) {
-			t.Fatalf("%s - effective rate too low %f, expected %.2f, error margin %f", tc.name, effectiveRate, tc.rate, errorMargin)
+	p := httptest.NewServer(rp)
+	defer p.Close()
+
+	if err := rp.SetRate(rate); err != nil {
+		t.Fatal(err)
+	}
+
+	start := clock.Now()
+
+	clock.Run(ratelimittest.Interval(rate), func() {
+		for i := 0; i < n; i++ {
+			get(t, p, "/")
 		}
+	})
+
+	// Requests are forwarded at the new rate, not after the interval of the initial one.
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, ratelimittest.TickerOffsets(n, rate)...)
+}
+
+// get sends a GET request for the path to the proxy and returns the response body.
+func get(t *testing.T, p *httptest.Server, path string) string {
+	t.Helper()
+
+	resp, err := p.Client().Get(p.URL + path)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
 	}
+	return string(b)
 }
 
-func startMultipleTestServers(n int, want string) ([]*url.URL, []*httptest.Server, error) {
+func startMultipleTestServers(clock *ratelimittest.Clock, n int, want string) ([]*url.URL, []*ratelimittest.Server, error) {
 
-	var srvs []*httptest.Server
+	var srvs []*ratelimittest.Server
 	var urls []*url.URL
 
 	for i := 0; i < n; i++ {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprint(w, want, r.URL.Path[1:])
 		}))
 		srvs = append(srvs, ts)
@@ -181,7 +295,7 @@ func startMultipleTestServers(n int, want string) ([]*url.URL, []*httptest.Serve
 	return urls, srvs, nil
 }
 
-func closeMultipleSrvs(srvs []*httptest.Server) {
+func closeMultipleSrvs(srvs []*ratelimittest.Server) {
 	for _, srv := range srvs {
 		srv.Close()
 	}
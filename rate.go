@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of events allowed per second.
+// A zero rate means no limit.
+// Rates can be built from a count and a period with Per or parsed from strings such as "100/m" with ParseRate.
+type Rate float64
+
+// units are the period units accepted by ParseRate, ordered from the shortest to the longest.
+var units = []struct {
+	names  []string
+	period time.Duration
+}{
+	{names: []string{"ms", "millisecond"}, period: time.Millisecond},
+	{names: []string{"s", "sec", "second"}, period: time.Second},
+	{names: []string{"m", "min", "minute"}, period: time.Minute},
+	{names: []string{"h", "hour"}, period: time.Hour},
+	{names: []string{"d", "day"}, period: 24 * time.Hour},
+}
+
+// Per returns the rate allowing count events per period.
+// If the period is not positive, it returns a zero rate.
+func Per(count float64, period time.Duration) Rate {
+	if period <= 0 {
+		return 0
+	}
+	return Rate(count / period.Seconds())
+}
+
+// Every returns the rate allowing one event every interval.
+// If the interval is not positive, it returns a zero rate.
+func Every(interval time.Duration) Rate {
+	return Per(1, interval)
+}
+
+// ParseRate parses a rate of the form "count/period".
+// The period is either a unit (ms, s, m, h, d and their long names, e.g. "100/m" or "5000/hour")
+// or a Go duration (e.g. "10/2s" or "30/1h30m").
+// A rate without period (e.g. "10") is a number of events per second.
+func ParseRate(s string) (Rate, error) {
+	count, period := strings.TrimSpace(s), "s"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, period = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("ratelimit: invalid rate %q: invalid count %q", s, count)
+	}
+
+	d, err := parsePeriod(period)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("ratelimit: invalid rate %q: invalid period %q", s, period)
+	}
+
+	return Per(n, d), nil
+}
+
+// parsePeriod parses a period unit or a Go duration.
+func parsePeriod(period string) (time.Duration, error) {
+	name := strings.ToLower(period)
+
+	for _, u := range units {
+		for _, n := range u.names {
+			if name == n || name == n+"s" {
+				return u.period, nil
+			}
+		}
+	}
+
+	return time.ParseDuration(period)
+}
+
+// String returns the rate as "count/unit", using the shortest unit for which the count is a whole number.
+// It can be parsed back with ParseRate.
+func (r Rate) String() string {
+	for _, u := range units[1:] {
+		count := float64(r) * u.period.Seconds()
+		if count >= 1 && math.Abs(count-math.Round(count)) < epsilon*count {
+			return fmt.Sprintf("%s/%s", strconv.FormatFloat(math.Round(count), 'f', -1, 64), u.names[0])
+		}
+	}
+
+	return fmt.Sprintf("%s/s", strconv.FormatFloat(float64(r), 'g', -1, 64))
+}
+
+// UnmarshalText parses the rate from its textual form, see ParseRate.
+func (r *Rate) UnmarshalText(text []byte) error {
+	rate, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// MarshalText returns the textual form of the rate, see String.
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
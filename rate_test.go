@@ -0,0 +1,97 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		input    string
+		expected ratelimit.Rate
+	}{
+		{input: "10/s", expected: ratelimit.Per(10, time.Second)},
+		{input: "100/m", expected: ratelimit.Per(100, time.Minute)},
+		{input: "5000/h", expected: ratelimit.Per(5000, time.Hour)},
+		{input: "5/hour", expected: ratelimit.Per(5, time.Hour)},
+		{input: "50000/d", expected: ratelimit.Per(50000, 24*time.Hour)},
+		{input: "10/2s", expected: ratelimit.Per(10, 2*time.Second)},
+		{input: "3 / 500ms", expected: ratelimit.Per(3, 500*time.Millisecond)},
+		{input: "0.5", expected: ratelimit.Per(0.5, time.Second)},
+		{input: "1e9/s", expected: ratelimit.Per(1e9, time.Second)},
+	}
+
+	for _, tc := range testCases {
+		got, err := ratelimit.ParseRate(tc.input)
+		if err != nil {
+			t.Fatalf("%s - %v", tc.input, err)
+		}
+
+		if got != tc.expected {
+			t.Fatalf("%s - got rate %v, expected %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestParseRateInvalid(t *testing.T) {
+	t.Parallel()
+
+	inputs := []string{"", "ten/s", "-1/s", "10/", "10/week", "10/0s", "10/-1m"}
+
+	for _, input := range inputs {
+		if _, err := ratelimit.ParseRate(input); err == nil {
+			t.Fatalf("%s - expected an error", input)
+		}
+	}
+}
+
+func TestRateString(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		rate     ratelimit.Rate
+		expected string
+	}{
+		{rate: ratelimit.Per(10, time.Second), expected: "10/s"},
+		{rate: ratelimit.Per(100, time.Minute), expected: "100/m"},
+		{rate: ratelimit.Per(5, time.Hour), expected: "5/h"},
+		{rate: ratelimit.Per(50000, 24*time.Hour), expected: "50000/d"},
+		{rate: ratelimit.Every(2 * time.Second), expected: "30/m"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.rate.String(); got != tc.expected {
+			t.Fatalf("got %s, expected %s", got, tc.expected)
+		}
+
+		parsed, err := ratelimit.ParseRate(tc.rate.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := float64(parsed - tc.rate); diff > 1e-9 || diff < -1e-9 {
+			t.Fatalf("%s - parsed back %v, expected %v", tc.expected, parsed, tc.rate)
+		}
+	}
+}
+
+func TestTokenBucketLowRate(t *testing.T) {
+	t.Parallel()
+
+	b := ratelimit.NewTokenBucket(ratelimit.Per(5, time.Hour), 1)
+	b.Allow()
+
+	r := b.Reserve()
+	defer r.Cancel()
+
+	d := r.Delay()
+	expected := 12 * time.Minute
+
+	if d > expected || d < expected-time.Second {
+		t.Fatalf("got delay %v at 5 per hour, expected %v", d, expected)
+	}
+}
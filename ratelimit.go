@@ -3,108 +3,665 @@
 package ratelimit
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 // HTTPClient is an HTTP client that rate limits requests.
+// Its transport rate limits every request, including those issued with the plain http.Client methods.
 // If the provided rate is zero, it defaults to a plain HTTP client.
-type httpClient struct {
+type HTTPClient struct {
 	http.Client
-	ticker *time.Ticker
+
+	// Cost returns the number of limiter slots consumed by a request.
+	// A zero cost lets the request through without waiting for the limiter and a negative cost fails with ErrInvalidCost.
+	// If nil, every request consumes a single slot.
+	Cost func(req *http.Request) int
+
+	// RetryAfter makes RateLimit methods, DoWithCost and DoWithReservation honor the Retry-After header
+	// of 429 and 503 responses.
+	// If nil, responses are returned as is.
+	RetryAfter *RetryAfterPolicy
+
+	// Retry makes RateLimit methods and DoWithCost retry failed requests with an exponential backoff.
+	// Each retry consumes limiter slots like the first attempt.
+	// If nil, failed requests are not retried.
+	Retry *RetryPolicy
+
+	// AdaptToServerQuota makes RateLimit methods, DoWithCost and DoWithReservation tune the client limiter from the quota advertised
+	// by the RateLimit-Remaining/RateLimit-Reset or X-RateLimit-Remaining/X-RateLimit-Reset response headers,
+	// so that the remaining quota is never exceeded before its reset.
+	// When limiting per host, the limiter of the responding host is tuned instead.
+	// It requires an Adaptable limiter such as the one created by NewHTTPClient or NewTokenBucket.
+	AdaptToServerQuota bool
+
+	limiter    Limiter
+	perRequest RequestLimiter
+	inFlight   *InFlightLimiter
+	read       *TokenBucket
+	write      *TokenBucket
+	clock      Clock
+	ownLimiter bool
+	transport  *Transport
+	done       chan struct{}
+	closeOnce  sync.Once
 }
 
 // DoWithRateLimit issues a rate limited do request.
 // All requests issued by this client using RateLimit methods share a common rate limiter.
-// Those reuqests are waiting for an available tick from a ticker channel.
-func (c *httpClient) DoWithRateLimit(req *http.Request) (resp *http.Response, err error) {
-	if c.ticker != nil {
-		<-c.ticker.C
+// Those requests are waiting for an available slot from the client limiter.
+// If the request context ends while waiting, the context error is returned without consuming a slot.
+func (c *HTTPClient) DoWithRateLimit(req *http.Request) (resp *http.Response, err error) {
+	return c.DoWithCost(req, c.cost(req))
+}
+
+// DoWithCost issues a rate limited do request consuming cost slots of the client limiter.
+// It allows expensive requests to use a larger share of the limiter than cheap ones.
+// If the request context ends while waiting, the context error is returned without consuming any slot.
+// If a RetryAfter or Retry policy is set, the request may be retried, each attempt consuming cost slots.
+// A zero cost sends the request without waiting for the limiter and a negative cost fails with ErrInvalidCost.
+func (c *HTTPClient) DoWithCost(req *http.Request, cost int) (resp *http.Response, err error) {
+	if isClosed(c.done) {
+		return nil, ErrLimiterClosed
+	}
+	if cost < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	for attempt := 0; ; attempt++ {
+		// Wait for the limiter before taking an in-flight slot,
+		// so that requests queued for a slow host do not block requests to other hosts.
+		var r Reservation
+		if cost > 0 {
+			if r, err = reserveOpen(req.Context(), c.limiterFor(req), cost, c.done); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := c.inFlight.Acquire(req.Context(), req); err != nil {
+			if r != nil {
+				r.Cancel()
+			}
+			return nil, err
+		}
+
+		resp, err = c.send(req, cost)
+		if err != nil {
+			if !c.retryable(req, attempt, nil, err) {
+				return resp, err
+			}
+			next, ok := rewindRequest(req)
+			if !ok {
+				return resp, err
+			}
+			if err := sleep(req.Context(), c.clock, c.Retry.backoff(attempt)); err != nil {
+				return nil, err
+			}
+			req = next
+			continue
+		}
+
+		now := c.clock.Now()
+
+		var backoff time.Duration
+		if until, wait, ok := c.honor(req, resp, now); ok {
+			backoff = wait
+
+			// The retry policy may also retry the request, but never waits longer than the RetryAfter policy allows.
+			retry := c.RetryAfter.retry(attempt, until, now) ||
+				c.RetryAfter.waitable(until, now) && c.retryable(req, attempt, resp, nil)
+			if !retry {
+				return resp, nil
+			}
+		} else if c.retryable(req, attempt, resp, nil) {
+			backoff = c.Retry.backoff(attempt)
+		} else {
+			return resp, nil
+		}
+
+		next, ok := rewindRequest(req)
+		if !ok {
+			return resp, nil
+		}
+
+		discardResponse(resp)
+		if err := sleep(req.Context(), c.clock, backoff); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+// honor applies the quota and back-off headers of the response to the limiter of the request.
+// It reports whether the server asks to back off, until when,
+// and how long the caller must wait by itself if the limiter cannot be paused.
+func (c *HTTPClient) honor(req *http.Request, resp *http.Response, now time.Time) (until time.Time, wait time.Duration, ok bool) {
+	if c.AdaptToServerQuota {
+		adaptLimiter(c.serverLimiter(req), resp, now)
+	}
+
+	until, ok = c.retryAfter(resp, now)
+	if !ok {
+		return until, 0, false
+	}
+
+	// The server asks to back off: pause the limiter for every request sharing it,
+	// or wait until then if it cannot be paused.
+	if !pauseLimiter(c.serverLimiter(req), until) {
+		wait = until.Sub(now)
+	}
+	return until, wait, true
+}
+
+// retryAfter returns the time until which the response asks clients to back off, if the client honors it.
+func (c *HTTPClient) retryAfter(resp *http.Response, now time.Time) (time.Time, bool) {
+	if c.RetryAfter == nil {
+		return time.Time{}, false
+	}
+	return c.RetryAfter.retryAfter(resp, now)
+}
+
+// retryable reports whether the client retry policy retries the given attempt.
+func (c *HTTPClient) retryable(req *http.Request, attempt int, resp *http.Response, err error) bool {
+	return c.Retry != nil && c.Retry.retryable(req, attempt, resp, err)
+}
+
+// TryDoWithRateLimit issues a rate limited do request only if a slot is available now.
+// It never waits: if the client limiter does not allow the request, ErrRateLimited is returned immediately,
+// and if the maximum number of requests in flight is reached, ErrTooManyInFlight is returned immediately.
+// The AdaptToServerQuota and RetryAfter settings apply to the limiter, but the request is never retried.
+func (c *HTTPClient) TryDoWithRateLimit(req *http.Request) (resp *http.Response, err error) {
+	if isClosed(c.done) {
+		return nil, ErrLimiterClosed
+	}
+
+	cost := c.cost(req)
+	if cost < 0 {
+		return nil, ErrInvalidCost
+	}
+	if !c.inFlight.TryAcquire(req) {
+		return nil, ErrTooManyInFlight
+	}
+	if cost > 0 && !c.limiterFor(req).AllowN(cost) {
+		c.inFlight.Release(req)
+		return nil, ErrRateLimited
 	}
-	return c.Do(req)
+	return c.sendOnce(req, cost)
+}
+
+// sendOnce sends a request admitted by the client limiters without retrying it,
+// applying the quota and back-off headers of the response to the limiter.
+func (c *HTTPClient) sendOnce(req *http.Request, cost int) (*http.Response, error) {
+	resp, err := c.send(req, cost)
+	if err == nil {
+		c.honor(req, resp, c.clock.Now())
+	}
+	return resp, err
+}
+
+// send sends a request admitted by the client limiters.
+// Its in-flight slot is held until the response body is closed.
+func (c *HTTPClient) send(req *http.Request, cost int) (*http.Response, error) {
+	resp, err := c.Do(c.admit(req, cost))
+	return holdUntilClosed(resp, err, func() { c.inFlight.Release(req) })
+}
+
+// limiterFor returns the limiter applied to the given request.
+func (c *HTTPClient) limiterFor(req *http.Request) Limiter {
+	return limiterFor(c.limiter, c.perRequest, req)
+}
+
+// serverLimiter returns the limiter tuned from the responses to the given request:
+// the limiter of the request host when limiting per host, so that other hosts are not slowed down,
+// the client limiter otherwise.
+func (c *HTTPClient) serverLimiter(req *http.Request) Limiter {
+	if c.perRequest != nil {
+		return c.perRequest.LimiterFor(req)
+	}
+	return c.limiter
+}
+
+// admit marks the request as admitted by the client limiter so that the client transport does not limit it twice.
+func (c *HTTPClient) admit(req *http.Request, cost int) *http.Request {
+	return req.WithContext(admitted(req.Context(), c.transport, cost))
+}
+
+// cost returns the number of slots consumed by the given request.
+func (c *HTTPClient) cost(req *http.Request) int {
+	if c.Cost == nil {
+		return 1
+	}
+	return c.Cost(req)
+}
+
+// DoWithReservation waits for the given reservation, then sends the request on the slot it holds
+// instead of consuming another slot of the client limiter.
+// When limiting per host, the request also waits for a slot of the limiter of its host,
+// as reservations only hold slots of the client limiter.
+// The AdaptToServerQuota and RetryAfter settings apply to the limiter,
+// but the request is not retried, as a retry would need another slot.
+// If the request is not sent, e.g. the context ends while waiting, the reservation is kept:
+// it is up to the caller to cancel it.
+func (c *HTTPClient) DoWithReservation(req *http.Request, r Reservation) (*http.Response, error) {
+	if err := untilClosed(req.Context(), c.done, r.Wait); err != nil {
+		return nil, err
+	}
+
+	cost := c.cost(req)
+	if cost < 0 {
+		return nil, ErrInvalidCost
+	}
+	var host Reservation
+	if c.perRequest != nil && cost > 0 {
+		var err error
+		if host, err = reserveOpen(req.Context(), c.perRequest.LimiterFor(req), cost, c.done); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.inFlight.Acquire(req.Context(), req); err != nil {
+		if host != nil {
+			host.Cancel()
+		}
+		return nil, err
+	}
+	return c.sendOnce(req, cost)
+}
+
+// Reserve reserves the next n slots of the client limiter.
+// Each reservation reports how long until its slot is usable, which allows computing an ETA for a batch of requests.
+// A request is sent on a reserved slot with DoWithReservation: other methods consume another slot.
+// Reservations that end up unused should be canceled to give their slot back.
+// If the client is closed, the reservations are not OK.
+func (c *HTTPClient) Reserve(n int) []Reservation {
+	if isClosed(c.done) {
+		return failN(n, ErrLimiterClosed)
+	}
+	return reserveN(c.limiter, n)
+}
+
+// SetRate changes the rate of the client limiter while requests may be waiting.
+// If the limiter is not tunable, ErrNotTunable is returned.
+func (c *HTTPClient) SetRate(rate Rate) error {
+	return setRate(c.limiter, rate)
+}
+
+// SetBurst changes the burst of the client limiter while requests may be waiting.
+// If the limiter is not tunable, ErrNotTunable is returned.
+func (c *HTTPClient) SetBurst(burst int) error {
+	return setBurst(c.limiter, burst)
+}
+
+// SetMaxQueue bounds the number of requests waiting for the client limiter.
+// Once the queue is full, RateLimit methods fail immediately with ErrQueueFull.
+// If the limiter does not support it, ErrNotTunable is returned.
+func (c *HTTPClient) SetMaxQueue(n int) error {
+	return setMaxQueue(c.limiter, n)
+}
+
+// SetMaxWait bounds the time a request may wait for the client limiter.
+// Requests not granted a slot in time fail with ErrWaitTimeout.
+// If the limiter does not support it, ErrNotTunable is returned.
+func (c *HTTPClient) SetMaxWait(d time.Duration) error {
+	return setMaxWait(c.limiter, d)
+}
+
+// SetMaxInFlight caps the number of requests in flight issued by the client, in addition to its rate.
+// A request holds its slot until its response body is closed.
+// RateLimit methods and plain http.Client methods block while the cap is reached, TryDoWithRateLimit rejects the request.
+// A zero maximum means no limit.
+func (c *HTTPClient) SetMaxInFlight(max int) {
+	c.inFlight.SetMax(max)
+}
+
+// SetMaxInFlightPerHost caps the number of requests in flight issued by the client to a single host.
+// A request holds its slot until its response body is closed.
+// A zero maximum means no limit.
+func (c *HTTPClient) SetMaxInFlightPerHost(max int) {
+	c.inFlight.SetMaxPerHost(max)
+}
+
+// SetBandwidth limits the rate at which the client reads response bodies and sends request bodies,
+// in bytes per second, e.g. ratelimit.Per(1<<20, time.Second) for 1 MiB/s.
+// Limits apply to the client as a whole, every request combined, and to bodies already being transferred.
+// A zero rate means no limit.
+func (c *HTTPClient) SetBandwidth(read, write Rate) {
+	c.read.SetBurst(bandwidthBurst(read))
+	c.read.SetRate(read)
+	c.write.SetBurst(bandwidthBurst(write))
+	c.write.SetRate(write)
 }
 
 // GetWithRateLimit issues a rate lmited get request.
 // All requests issued by this client using RateLimit methods share a common rate limiter.
-// Those reuqests are waiting for an available tick from a ticker channel.
-func (c *httpClient) GetWithRateLimit(url string) (resp *http.Response, err error) {
-	if c.ticker != nil {
-		<-c.ticker.C
+// Those requests are waiting for an available slot from the client limiter.
+func (c *HTTPClient) GetWithRateLimit(url string) (resp *http.Response, err error) {
+	return c.GetWithRateLimitContext(context.Background(), url)
+}
+
+// GetWithRateLimitContext issues a rate limited get request with the given context.
+// If the context ends while waiting, the context error is returned without consuming a slot.
+func (c *HTTPClient) GetWithRateLimitContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
-	return c.Get(url)
+	return c.DoWithRateLimit(req)
 }
 
 // HeadWithRateLimit issues a rate lmited head request.
 // All requests issued by this client using RateLimit methods share a common rate limiter.
-// Those reuqests are waiting for an available tick from a ticker channel.
-func (c *httpClient) HeadWithRateLimit(url string) (resp *http.Response, err error) {
-	if c.ticker != nil {
-		<-c.ticker.C
+// Those requests are waiting for an available slot from the client limiter.
+func (c *HTTPClient) HeadWithRateLimit(url string) (resp *http.Response, err error) {
+	return c.HeadWithRateLimitContext(context.Background(), url)
+}
+
+// HeadWithRateLimitContext issues a rate limited head request with the given context.
+// If the context ends while waiting, the context error is returned without consuming a slot.
+func (c *HTTPClient) HeadWithRateLimitContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
 	}
-	return c.Head(url)
+	return c.DoWithRateLimit(req)
 }
 
 // PostWithRateLimit issues a rate limited post request.
 // All requests issued by this client using RateLimit methods share a common rate limiter.
-// Those reuqests are waiting for an available tick from a ticker channel.
-func (c *httpClient) PostWithRateLimit(url, contentType string, body io.Reader) (resp *http.Response, err error) {
-	if c.ticker != nil {
-		<-c.ticker.C
+// Those requests are waiting for an available slot from the client limiter.
+func (c *HTTPClient) PostWithRateLimit(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+	return c.PostWithRateLimitContext(context.Background(), url, contentType, body)
+}
+
+// PostWithRateLimitContext issues a rate limited post request with the given context.
+// If the context ends while waiting, the context error is returned without consuming a slot.
+func (c *HTTPClient) PostWithRateLimitContext(ctx context.Context, url, contentType string, body io.Reader) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
 	}
-	return c.Post(url, contentType, body)
+	req.Header.Set("Content-Type", contentType)
+	return c.DoWithRateLimit(req)
 }
 
 // PostFormWithRateLimit issues a rate limited post form request.
 // All requests issued by this client using RateLimit methods share a common rate limiter.
-// Those reuqests are waiting for an available tick from a ticker channel.
-func (c *httpClient) PostFormWithRateLimit(url string, data url.Values) (resp *http.Response, err error) {
-	if c.ticker != nil {
-		<-c.ticker.C
+// Those requests are waiting for an available slot from the client limiter.
+func (c *HTTPClient) PostFormWithRateLimit(url string, data url.Values) (resp *http.Response, err error) {
+	return c.PostFormWithRateLimitContext(context.Background(), url, data)
+}
+
+// PostFormWithRateLimitContext issues a rate limited post form request with the given context.
+// If the context ends while waiting, the context error is returned without consuming a slot.
+func (c *HTTPClient) PostFormWithRateLimitContext(ctx context.Context, url string, data url.Values) (resp *http.Response, err error) {
+	return c.PostWithRateLimitContext(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// Close stops the client limiter.
+// Requests waiting for the limiter or for a slot in flight are released with ErrLimiterClosed
+// and subsequent RateLimit calls fail fast with ErrLimiterClosed.
+// A limiter provided to NewHTTPClientWithLimiter is left open as it may be shared.
+// Idle connections are left open as the transport may be shared too.
+func (c *HTTPClient) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.inFlight.Close()
+		if c.ownLimiter {
+			err = closeLimiter(c.limiter)
+		}
+	})
+
+	return err
+}
+
+// New returns an http client rate limited at the given rate, configured by the given options.
+// The client should be closed once it is no longer used.
+// Its transport is a Transport using the client limiter, so that every request is limited.
+// If the transport is replaced, only requests issued using RateLimit methods are limited:
+// use WithTransport or wrap the new transport with NewTransport to keep limiting every request.
+// If the provided rate is zero, it defaults to a plain HTTP client.
+func New(rate Rate, opts ...Option) *HTTPClient {
+	o := newOptions(opts)
+	l, own := o.newLimiter(rate)
+
+	c := &HTTPClient{
+		Cost:               o.cost,
+		Retry:              o.retry,
+		RetryAfter:         o.retryAfter,
+		AdaptToServerQuota: o.adapt,
+		limiter:            l,
+		perRequest:         o.hosts,
+		inFlight:           NewInFlightLimiter(o.maxInFlight, o.maxPerHost),
+		read:               o.newBandwidthLimiter(o.read),
+		write:              o.newBandwidthLimiter(o.write),
+		clock:              o.clock,
+		ownLimiter:         own,
+		done:               make(chan struct{}),
+	}
+
+	c.transport = &Transport{
+		Base:         o.transport,
+		Limiter:      l,
+		PerRequest:   o.hosts,
+		Concurrency:  c.inFlight,
+		ReadLimiter:  c.read,
+		WriteLimiter: c.write,
+		Cost:         c.cost,
+		done:         c.done,
 	}
-	return c.PostForm(url, data)
+	c.Transport = c.transport
+
+	return c
 }
 
 // NewHTTPClient returns a rate limited http client.
-func NewHTTPClient(rate float64) *httpClient {
-	c := httpClient{}
+// The client should be closed once it is no longer used.
+func NewHTTPClient(rate Rate) *HTTPClient {
+	return New(rate)
+}
 
-	if rate != 0.0 {
-		tickInterval := time.Duration(1e9/rate) * time.Nanosecond
-		c.ticker = time.NewTicker(tickInterval)
+// NewHTTPClientWithLimiter returns an http client rate limited by the given limiter.
+// The limiter can be shared with other clients, workers or proxies.
+// The client transport is a Transport using the limiter, so that every request is limited.
+// If the transport is replaced, only requests issued using RateLimit methods are limited:
+// wrap the new transport with NewTransport to keep limiting every request.
+// If the provided limiter is nil, it defaults to a plain HTTP client.
+func NewHTTPClientWithLimiter(l Limiter) *HTTPClient {
+	if l == nil {
+		l = NewLimiter(0)
 	}
+	return New(0, WithLimiter(l))
+}
+
+// NewHTTPClientPerHost returns an http client keeping an independent limiter per request host,
+// created lazily by the given host limiter, so that a slow host does not throttle traffic to other hosts.
+// The client limiter does not limit requests until its rate is set with SetRate,
+// in which case it caps the traffic to every host combined.
+// The host limiter can be shared with other clients and is left open when the client is closed.
+func NewHTTPClientPerHost(h RequestLimiter) *HTTPClient {
+	return New(0, WithHostLimiter(h))
+}
 
-	return &c
+// worker holds the limiter shared by the executions of a Worker or a FuncWorker.
+type worker struct {
+	limiter    Limiter
+	ownLimiter bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// wait waits for the slots of an execution of the given cost.
+func (w *worker) wait(ctx context.Context, cost int) error {
+	if isClosed(w.done) {
+		return ErrLimiterClosed
+	}
+	if cost < 0 {
+		return ErrInvalidCost
+	}
+	return waitOpen(ctx, w.limiter, executionCost(cost), w.done)
+}
+
+// allow takes the slots of an execution of the given cost only if they are available now.
+func (w *worker) allow(cost int) error {
+	if isClosed(w.done) {
+		return ErrLimiterClosed
+	}
+	if cost < 0 {
+		return ErrInvalidCost
+	}
+	if !w.limiter.AllowN(executionCost(cost)) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// executionCost returns the number of slots consumed by each execution of a worker with the given cost.
+func executionCost(cost int) int {
+	if cost == 0 {
+		return 1
+	}
+	return cost
+}
+
+// Reserve reserves the next n slots of the worker limiter.
+// Each reservation reports how long until its slot is usable, which allows computing an ETA for a batch of executions.
+// Reservations that end up unused should be canceled to give their slot back.
+// If the worker is closed, the reservations are not OK.
+func (w *worker) Reserve(n int) []Reservation {
+	if isClosed(w.done) {
+		return failN(n, ErrLimiterClosed)
+	}
+	return reserveN(w.limiter, n)
+}
+
+// SetRate changes the rate of the worker limiter while functions may be waiting.
+// If the limiter is not tunable, ErrNotTunable is returned.
+func (w *worker) SetRate(rate Rate) error {
+	return setRate(w.limiter, rate)
+}
+
+// SetBurst changes the burst of the worker limiter while functions may be waiting.
+// If the limiter is not tunable, ErrNotTunable is returned.
+func (w *worker) SetBurst(burst int) error {
+	return setBurst(w.limiter, burst)
+}
+
+// SetMaxQueue bounds the number of functions waiting for the worker limiter.
+// Once the queue is full, executions fail immediately with ErrQueueFull.
+// If the limiter does not support it, ErrNotTunable is returned.
+func (w *worker) SetMaxQueue(n int) error {
+	return setMaxQueue(w.limiter, n)
+}
+
+// SetMaxWait bounds the time a function may wait for the worker limiter.
+// Functions not granted a slot in time are not executed and fail with ErrWaitTimeout.
+// If the limiter does not support it, ErrNotTunable is returned.
+func (w *worker) SetMaxWait(d time.Duration) error {
+	return setMaxWait(w.limiter, d)
+}
+
+// Close stops the worker limiter.
+// Functions waiting for the limiter are released with ErrLimiterClosed without being executed
+// and subsequent calls fail fast with ErrLimiterClosed.
+// A limiter provided with WithLimiter or to NewWorkerWithLimiter is left open as it may be shared.
+func (w *worker) Close() error {
+	var err error
+
+	w.closeOnce.Do(func() {
+		close(w.done)
+		if w.ownLimiter {
+			err = closeLimiter(w.limiter)
+		}
+	})
+
+	return err
+}
+
+// newWorker returns the limiter of a worker limited at the given rate, configured by the given options.
+func newWorker(rate Rate, opts []Option) worker {
+	l, own := newOptions(opts).newLimiter(rate)
+
+	return worker{
+		limiter:    l,
+		ownLimiter: own,
+		done:       make(chan struct{}),
+	}
 }
 
 // Worker executes a given function at a given rate.
 // If the provided rate is zero, it defaults to the provided function.
-type worker struct {
-	ticker *time.Ticker
-	do     func()
+type Worker struct {
+	// Cost is the number of limiter slots consumed by each execution.
+	// If zero, every execution consumes a single slot.
+	// A negative cost fails with ErrInvalidCost.
+	Cost int
+
+	worker
+	do func()
 }
 
 // DoWithRateLimit executes the worker functionality at a given rate.
 // All function exectued by this worker shares a common rate limiter.
-// Those requests are waiting for an available tick from a ticker channel.
-func (w *worker) DoWithRateLimit() {
-	if w.ticker != nil {
-		<-w.ticker.C
+// Those functions are waiting for an available slot from the worker limiter.
+// If the function cannot wait, e.g. the limiter queue is full, it is not executed:
+// use DoWithRateLimitContext to get the reason.
+func (w *Worker) DoWithRateLimit() {
+	w.DoWithRateLimitContext(context.Background())
+}
+
+// DoWithRateLimitContext executes the worker functionality at a given rate with the given context.
+// If the context ends while waiting, the function is not executed
+// and the context error is returned without consuming a slot.
+func (w *Worker) DoWithRateLimitContext(ctx context.Context) error {
+	if err := w.wait(ctx, w.Cost); err != nil {
+		return err
+	}
+	w.do()
+	return nil
+}
+
+// TryDoWithRateLimit executes the worker functionality only if a slot is available now.
+// It never waits: if the worker limiter does not allow the execution, ErrRateLimited is returned immediately.
+func (w *Worker) TryDoWithRateLimit() error {
+	if err := w.allow(w.Cost); err != nil {
+		return err
 	}
 	w.do()
+	return nil
 }
 
-// NewWorker returns a rate limited worker
-func NewWorker(rate float64, f func()) *worker {
-	w := worker{
-		do: f,
+// NewWorker returns a rate limited worker, configured by the given options.
+// The worker should be closed once it is no longer used.
+func NewWorker(rate Rate, f func(), opts ...Option) *Worker {
+	return &Worker{
+		worker: newWorker(rate, opts),
+		do:     f,
 	}
+}
 
-	if rate != 0.0 {
-		tickInterval := time.Duration(1e9/rate) * time.Nanosecond
-		w.ticker = time.NewTicker(tickInterval)
+// NewWorkerWithLimiter returns a worker rate limited by the given limiter.
+// The limiter can be shared with other clients, workers or proxies.
+// If the provided limiter is nil, it defaults to the provided function.
+func NewWorkerWithLimiter(l Limiter, f func()) *Worker {
+	if l == nil {
+		l = NewLimiter(0)
 	}
+	return NewWorker(0, f, WithLimiter(l))
+}
 
-	return &w
+// isClosed reports whether the given done channel has been closed.
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
 }
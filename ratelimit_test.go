@@ -1,7 +1,10 @@
 package ratelimit_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -9,77 +12,61 @@ import (
 	"time"
 
 	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
 )
 
 func TestHttpMethodsWithRateLimit(t *testing.T) {
-	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "Hello World!")
-	}))
-	defer ts.Close()
-
-	client := ts.Client()
-	noLimitThreshold := 100.0
-	errorMargin := 0.05
-
 	testCases := []struct {
 		name   string
 		method string
-		url    string
 		number int
-		rate   float64
+		rate   ratelimit.Rate
 	}{
-		{name: "DO 2 reqs 1 QPS", method: "DO", url: ts.URL, number: 2, rate: 1.0},
-		{name: "GET 2 reqs 1 QPS", method: "GET", url: ts.URL, number: 2, rate: 1.0},
-		{name: "HEAD 2 reqs 1 QPS", method: "HEAD", url: ts.URL, number: 2, rate: 1.0},
-		{name: "POST 2 reqs 1 QPS", method: "POST", url: ts.URL, number: 2, rate: 1.0},
-		{name: "POSTFORM 2 reqs 1 QPS", method: "POSTFORM", url: ts.URL, number: 2, rate: 1.0},
-		{name: "DO no rate limit", method: "DO", url: ts.URL, number: 2, rate: 0.0},
+		{name: "DO 2 reqs 1 QPS", method: "DO", number: 2, rate: 1.0},
+		{name: "GET 2 reqs 1 QPS", method: "GET", number: 2, rate: 1.0},
+		{name: "HEAD 2 reqs 1 QPS", method: "HEAD", number: 2, rate: 1.0},
+		{name: "POST 2 reqs 1 QPS", method: "POST", number: 2, rate: 1.0},
+		{name: "POSTFORM 2 reqs 1 QPS", method: "POSTFORM", number: 2, rate: 1.0},
+		{name: "DO no rate limit", method: "DO", number: 2, rate: 0.0},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			c := ratelimit.NewHTTPClient(tc.rate)
-			c.Transport = client.Transport
-
-			start := time.Now()
-
-			for i := 0; i < tc.number; i++ {
-				switch tc.method {
-				case "DO":
-					req, err := http.NewRequest("GET", tc.url, nil)
-					if err != nil {
-						t.Errorf("%s - %v", tc.name, err)
-					}
-					c.DoWithRateLimit(req)
-				case "GET":
-					c.GetWithRateLimit(tc.url)
-				case "HEAD":
-					c.HeadWithRateLimit(tc.url)
-				case "POST":
-					c.PostWithRateLimit(tc.url, "", nil)
-				case "POSTFORM":
-					c.PostFormWithRateLimit(tc.url, nil)
-				default:
-					t.Errorf("%s - invalid method %v", tc.name, tc.method)
-				}
-			}
+			clock := ratelimittest.NewClock(time.Now())
 
-			stop := time.Now()
-			duration := stop.Sub(start).Seconds()
-			effectiveRate := float64(tc.number) / duration
+			ts := ratelimittest.NewTLSServer(clock, nil)
+			defer ts.Close()
 
-			if tc.rate == 0 && effectiveRate < noLimitThreshold {
-				t.Errorf("%s - effective rate %f, no limit threshold %.2f", tc.name, effectiveRate, noLimitThreshold)
-			}
+			c := ratelimit.New(tc.rate, ratelimit.WithClock(clock))
+			defer c.Close()
+			c.Transport = ts.Client().Transport
 
-			if tc.rate != 0 && effectiveRate > tc.rate {
-				t.Errorf("%s - effective rate too high %f, expected %.2f", tc.name, effectiveRate, tc.rate)
-			}
+			start := clock.Now()
 
-			if tc.rate != 0 && effectiveRate < (tc.rate-errorMargin) {
-				t.Errorf("%s - effective rate too low %f, expected %.2f, error margin %f", tc.name, effectiveRate, tc.rate, errorMargin)
-			}
+			clock.Run(ratelimittest.Interval(tc.rate), func() {
+				for i := 0; i < tc.number; i++ {
+					switch tc.method {
+					case "DO":
+						req, err := http.NewRequest("GET", ts.URL, nil)
+						if err != nil {
+							t.Errorf("%s - %v", tc.name, err)
+						}
+						c.DoWithRateLimit(req)
+					case "GET":
+						c.GetWithRateLimit(ts.URL)
+					case "HEAD":
+						c.HeadWithRateLimit(ts.URL)
+					case "POST":
+						c.PostWithRateLimit(ts.URL, "", nil)
+					case "POSTFORM":
+						c.PostFormWithRateLimit(ts.URL, nil)
+					default:
+						t.Errorf("%s - invalid method %v", tc.name, tc.method)
+					}
+				}
+			})
 
+			ratelimittest.AssertTimes(t, ts.Arrivals(), start, ratelimittest.TickerOffsets(tc.number, tc.rate)...)
 		})
 	}
 }
@@ -89,37 +76,572 @@ func TestWorkerDoWithRateLimit(t *testing.T) {
 
 	testCases := []struct {
 		name         string
-		expectedRate float64
+		expectedRate ratelimit.Rate
 		f            func()
 		n            int
 	}{
 		{name: "2 requests - 1 QPS", expectedRate: 1.0, f: func() { fmt.Println("Hello") }, n: 2},
+		{name: "10 requests - 5 QPS", expectedRate: 5.0, f: func() {}, n: 10},
 	}
 
 	for _, tc := range testCases {
-		var wg sync.WaitGroup
+		clock := ratelimittest.NewClock(time.Now())
+		l := ratelimittest.NewRecordingLimiter(ratelimit.NewLimiter(tc.expectedRate, ratelimit.WithClock(clock)), clock)
+
+		w := ratelimit.NewWorkerWithLimiter(l, tc.f)
+		defer l.Close()
+
+		start := clock.Now()
+
+		clock.Run(ratelimittest.Interval(tc.expectedRate), func() {
+			var wg sync.WaitGroup
+
+			wg.Add(tc.n)
+			for i := 0; i < tc.n; i++ {
+				go func() {
+					w.DoWithRateLimit()
+					wg.Done()
+				}()
+			}
+
+			wg.Wait()
+		})
+
+		ratelimittest.AssertTimes(t, l.Events(), start, ratelimittest.TickerOffsets(tc.n, tc.expectedRate)...)
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	rate := ratelimit.Rate(10)
+	n := 4
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewLimiter(rate, ratelimit.WithClock(clock)), clock)
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Transport = ts.Client().Transport
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+
+	start := clock.Now()
+
+	clock.Run(ratelimittest.Interval(rate), func() {
+		for i := 0; i < n/2; i++ {
+			if _, err := c.GetWithRateLimit(ts.URL); err != nil {
+				t.Error(err)
+			}
+			w.DoWithRateLimit()
+		}
+	})
+
+	ratelimittest.AssertTimes(t, l.Events(), start, ratelimittest.TickerOffsets(n, rate)...)
+}
+
+func TestHTTPClientContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(1.0, 1, ratelimit.WithClock(clock))
+	l.Allow()
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Transport = ts.Client().Transport
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.GetWithRateLimitContext(ctx, ts.URL)
+		errs <- err
+	}()
+	waitQueued(t, l, 1)
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
 
-		w := ratelimit.NewWorker(tc.expectedRate, tc.f)
+	// The canceled request must not have consumed the next slot.
+	if d := l.Reserve().Delay(); d != time.Second {
+		t.Fatalf("next slot delay %v, expected 1s", d)
+	}
+}
+
+func TestWorkerContextCanceled(t *testing.T) {
+	t.Parallel()
 
-		start := time.Now()
+	executed := false
+	w := ratelimit.NewWorker(0.1, func() { executed = true })
+	defer w.Close()
 
-		wg.Add(tc.n)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-		for i := 0; i < tc.n; i++ {
+	if err := w.DoWithRateLimitContext(ctx); err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	if executed {
+		t.Fatal("function executed after context cancellation")
+	}
+}
+
+func TestHTTPClientClose(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	c := ratelimit.New(0.1, ratelimit.WithClock(clock))
+
+	errs := make(chan error)
+	go func() {
+		_, err := c.GetWithRateLimit("http://127.0.0.1")
+		errs <- err
+	}()
+
+	// Wait for the request to wait for the limiter.
+	clock.BlockUntil(1)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != ratelimit.ErrLimiterClosed {
+			t.Fatalf("got error %v, expected %v", err, ratelimit.ErrLimiterClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting request not released by Close")
+	}
+
+	if _, err := c.GetWithRateLimit("http://127.0.0.1"); err != ratelimit.ErrLimiterClosed {
+		t.Fatalf("got error %v after close, expected %v", err, ratelimit.ErrLimiterClosed)
+	}
+}
+
+func TestWorkerCloseKeepsSharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(1.0, 2)
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+	w.Close()
+
+	if err := w.DoWithRateLimitContext(context.Background()); err != ratelimit.ErrLimiterClosed {
+		t.Fatalf("got error %v after close, expected %v", err, ratelimit.ErrLimiterClosed)
+	}
+
+	if !l.Allow() {
+		t.Fatal("shared limiter closed by worker")
+	}
+}
+
+func TestCloseReleasesSharedLimiterWaiters(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name string
+		// start returns a closer and a call waiting for the given shared limiter.
+		start func(l ratelimit.Limiter) (io.Closer, func() error)
+	}{
+		{
+			name: "client",
+			start: func(l ratelimit.Limiter) (io.Closer, func() error) {
+				c := ratelimit.NewHTTPClientWithLimiter(l)
+				return c, func() error {
+					_, err := c.GetWithRateLimit(ts.URL)
+					return err
+				}
+			},
+		},
+		{
+			name: "client transport",
+			start: func(l ratelimit.Limiter) (io.Closer, func() error) {
+				c := ratelimit.NewHTTPClientWithLimiter(l)
+				return c, func() error {
+					_, err := c.Get(ts.URL)
+					return err
+				}
+			},
+		},
+		{
+			name: "worker",
+			start: func(l ratelimit.Limiter) (io.Closer, func() error) {
+				w := ratelimit.NewWorkerWithLimiter(l, func() {})
+				return w, func() error {
+					return w.DoWithRateLimitContext(context.Background())
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+			defer l.Close()
+			l.Allow()
+
+			closer, call := tc.start(l)
+
+			errs := make(chan error, 1)
 			go func() {
-				w.DoWithRateLimit()
-				wg.Done()
+				errs <- call()
 			}()
+			waitQueued(t, l, 1)
+
+			closer.Close()
+
+			select {
+			case err := <-errs:
+				if !errors.Is(err, ratelimit.ErrLimiterClosed) {
+					t.Fatalf("got %v, expected %v", err, ratelimit.ErrLimiterClosed)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("waiting call not released by Close")
+			}
+
+			if l.Waiting() != 0 {
+				t.Fatalf("got %d waiters on the shared limiter after Close, expected 0", l.Waiting())
+			}
+		})
+	}
+}
+
+func TestTryDoWithRateLimit(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	// The clock never moves: the calls would block forever if they waited for the limiter.
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(0.1, 2, ratelimit.WithClock(clock))
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Transport = ts.Client().Transport
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.TryDoWithRateLimit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := w.TryDoWithRateLimit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.TryDoWithRateLimit(req); err != ratelimit.ErrRateLimited {
+		t.Fatalf("client got error %v, expected %v", err, ratelimit.ErrRateLimited)
+	}
+
+	if err := w.TryDoWithRateLimit(); err != ratelimit.ErrRateLimited {
+		t.Fatalf("worker got error %v, expected %v", err, ratelimit.ErrRateLimited)
+	}
+}
+
+func TestTryDoWithRateLimitServerHeaders(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "Retry-After", headers: map[string]string{"Retry-After": "2"}, status: http.StatusTooManyRequests},
+		{name: "exhausted quota", headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "2"}, status: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		clock := ratelimittest.NewClock(time.Now())
+
+		ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range tc.headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(tc.status)
+		}))
+		defer ts.Close()
+
+		l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10, ratelimit.WithClock(clock))
+		c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithClock(clock),
+			ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{}), ratelimit.WithServerQuota())
+		defer c.Close()
+
+		resp, err := c.TryDoWithRateLimit(mustRequest(t, ts.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		r := l.Reserve()
+		if d := r.Delay(); d < 2*time.Second {
+			t.Fatalf("%s - got delay %v, expected the headers to hold the limiter for 2s", tc.name, d)
+		}
+		r.Cancel()
+	}
+}
+
+func TestWorkerReserve(t *testing.T) {
+	t.Parallel()
+
+	rate := 10.0
+	n := 4
+	interval := time.Duration(float64(time.Second) / rate)
+
+	clock := ratelimittest.NewClock(time.Now())
+	w := ratelimit.NewWorkerWithLimiter(ratelimit.NewTokenBucket(ratelimit.Rate(rate), 1, ratelimit.WithClock(clock)), func() {})
+
+	rs := w.Reserve(n)
+
+	// Each reservation is usable one interval after the previous one.
+	for i, r := range rs {
+		if !r.OK() {
+			t.Fatalf("reservation %d not OK", i)
+		}
+
+		if d, expected := r.Delay(), time.Duration(i)*interval; d != expected {
+			t.Fatalf("reservation %d delay %v, expected %v", i, d, expected)
 		}
+	}
+
+	// Canceling unused reservations gives their slots back.
+	for _, r := range rs[1:] {
+		r.Cancel()
+	}
+
+	next := w.Reserve(1)[0]
+	if d := next.Delay(); d != interval {
+		t.Fatalf("delay after cancel %v, expected %v", d, interval)
+	}
+
+	clock.Advance(interval)
+	if err := next.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rs[1].Wait(context.Background()); err != ratelimit.ErrReservationCanceled {
+		t.Fatalf("got error %v on canceled reservation, expected %v", err, ratelimit.ErrReservationCanceled)
+	}
+}
+
+func TestHTTPClientDoWithReservation(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
 
-		wg.Wait()
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 2)
+	defer l.Close()
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	defer c.Close()
+
+	r := c.Reserve(1)[0]
+
+	resp, err := c.DoWithReservation(mustRequest(t, ts.URL), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The request was sent on the reserved slot: the second slot of the burst is still available.
+	if !l.Allow() {
+		t.Fatal("request sent on a reservation consumed another slot")
+	}
+
+	c.Close()
+	if _, err := c.DoWithReservation(mustRequest(t, ts.URL), c.Reserve(1)[0]); err != ratelimit.ErrLimiterClosed {
+		t.Fatalf("got %v after close, expected %v", err, ratelimit.ErrLimiterClosed)
+	}
+}
+
+func TestHTTPClientCost(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
 
-		stop := time.Now()
-		duration := stop.Sub(start).Seconds()
-		effectiveRate := float64(tc.n) / duration
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 6)
 
-		if effectiveRate > tc.expectedRate {
-			t.Fatalf("effective rate %.2f, expected %.2f", effectiveRate, tc.expectedRate)
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Transport = ts.Client().Transport
+	c.Cost = func(req *http.Request) int {
+		if req.Method == http.MethodPost {
+			return 5
 		}
+		return 1
+	}
+
+	get, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post, err := http.NewRequest(http.MethodPost, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []*http.Request{post, get} {
+		resp, err := c.TryDoWithRateLimit(req)
+		if err != nil {
+			t.Fatalf("%s - %v", req.Method, err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := c.TryDoWithRateLimit(get); err != ratelimit.ErrRateLimited {
+		t.Fatalf("got error %v, expected %v", err, ratelimit.ErrRateLimited)
+	}
+}
+
+func TestWorkerCost(t *testing.T) {
+	t.Parallel()
+
+	w := ratelimit.NewWorkerWithLimiter(ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 10), func() {})
+	w.Cost = 5
+
+	for i := 0; i < 2; i++ {
+		if err := w.TryDoWithRateLimit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.TryDoWithRateLimit(); err != ratelimit.ErrRateLimited {
+		t.Fatalf("got error %v, expected %v", err, ratelimit.ErrRateLimited)
+	}
+}
+
+func TestInvalidCost(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1)
+	defer l.Close()
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Transport = ts.Client().Transport
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.DoWithCost(req, -1); err != ratelimit.ErrInvalidCost {
+		t.Fatalf("DoWithCost got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	hc := &http.Client{Transport: ratelimit.NewTransport(l, ts.Client().Transport)}
+	if _, err := hc.Do(req.WithContext(ratelimit.WithCost(req.Context(), -1))); !errors.Is(err, ratelimit.ErrInvalidCost) {
+		t.Fatalf("WithCost got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	c.Cost = func(req *http.Request) int { return -1 }
+	if _, err := c.TryDoWithRateLimit(req); err != ratelimit.ErrInvalidCost {
+		t.Fatalf("Cost got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+	w.Cost = -1
+	if err := w.DoWithRateLimitContext(context.Background()); err != ratelimit.ErrInvalidCost {
+		t.Fatalf("worker got error %v, expected %v", err, ratelimit.ErrInvalidCost)
+	}
+
+	// Negative costs never add slots to the limiter.
+	if !l.Allow() {
+		t.Fatal("event refused, expected the bucket to be full")
+	}
+	if l.Allow() {
+		t.Fatal("event allowed on a drained bucket after negative costs")
+	}
+
+	// Requests costing nothing go through a drained limiter.
+	c.Cost = func(req *http.Request) int { return 0 }
+	resp, err := c.TryDoWithRateLimit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestWorkerMaxQueue(t *testing.T) {
+	t.Parallel()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1)
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+	defer w.Close()
+
+	if err := w.SetMaxQueue(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.TryDoWithRateLimit(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- w.DoWithRateLimitContext(ctx)
+	}()
+	waitQueued(t, l, 1)
+
+	if err := w.DoWithRateLimitContext(context.Background()); err != ratelimit.ErrQueueFull {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrQueueFull)
+	}
+
+	cancel()
+	if err := <-waiting; err != context.Canceled {
+		t.Fatalf("queued execution: got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestHTTPClientMaxWaitNotTunable(t *testing.T) {
+	t.Parallel()
+
+	c := ratelimit.NewHTTPClientWithLimiter(ratelimit.NewMultiLimiter(ratelimit.NewLimiter(0)))
+	defer c.Close()
+
+	if err := c.SetMaxWait(time.Second); err != ratelimit.ErrNotTunable {
+		t.Fatalf("got %v, expected %v", err, ratelimit.ErrNotTunable)
 	}
 }
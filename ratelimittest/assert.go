@@ -0,0 +1,125 @@
+package ratelimittest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+// epsilon absorbs floating point errors when comparing token counts.
+const epsilon = 1e-9
+
+// ObservedRate returns the rate at which the events happened:
+// the slots consumed after the first event divided by the time elapsed since it.
+// It returns zero if the events did not span any time.
+func ObservedRate(events []Event) ratelimit.Rate {
+	if len(events) < 2 {
+		return 0
+	}
+
+	var slots int
+	for _, e := range events[1:] {
+		slots += e.N
+	}
+
+	return ratelimit.Per(float64(slots), events[len(events)-1].At.Sub(events[0].At))
+}
+
+// ObservedBurst returns the largest number of slots consumed by events happening at the same time.
+func ObservedBurst(events []Event) int {
+	var burst, current int
+
+	for i, e := range events {
+		if i == 0 || !e.At.Equal(events[i-1].At) {
+			current = 0
+		}
+		current += e.N
+		if current > burst {
+			burst = current
+		}
+	}
+
+	return burst
+}
+
+// AssertConforms reports an error if the events exceed what a token bucket allowing the given rate
+// with bursts of up to burst slots allows, starting full.
+// Events costing more than the burst are allowed once the bucket is full, the following events paying them back.
+// A zero rate allows every event.
+func AssertConforms(t testing.TB, events []Event, rate ratelimit.Rate, burst int) {
+	t.Helper()
+
+	if rate == 0 || len(events) == 0 {
+		return
+	}
+
+	tokens := float64(burst)
+	last := events[0].At
+
+	for i, e := range events {
+		tokens += e.At.Sub(last).Seconds() * float64(rate)
+		if tokens > float64(burst) {
+			tokens = float64(burst)
+		}
+		last = e.At
+
+		need := math.Min(float64(e.N), float64(burst))
+		if tokens < need-epsilon {
+			t.Errorf("event %d at %v exceeds %v with a burst of %d", i+1, e.At.Sub(events[0].At), rate, burst)
+			return
+		}
+		tokens -= float64(e.N)
+	}
+}
+
+// AssertRate reports an error if the observed rate of the events differs from the given rate
+// by more than the given relative tolerance, e.g. 0.01 for 1%.
+func AssertRate(t testing.TB, events []Event, rate ratelimit.Rate, tolerance float64) {
+	t.Helper()
+
+	observed := ObservedRate(events)
+	if math.Abs(float64(observed-rate)) > tolerance*float64(rate) {
+		t.Errorf("observed rate %v over %d events, expected %v", observed, len(events), rate)
+	}
+}
+
+// AssertTimes reports an error if the events did not happen at the given offsets from the start time.
+func AssertTimes(t testing.TB, events []Event, start time.Time, offsets ...time.Duration) {
+	t.Helper()
+
+	if len(events) != len(offsets) {
+		t.Errorf("got %d events, expected %d", len(events), len(offsets))
+		return
+	}
+
+	for i, e := range events {
+		if got := e.At.Sub(start); got != offsets[i] {
+			t.Errorf("event %d at %v, expected %v", i+1, got, offsets[i])
+		}
+	}
+}
+
+// Interval returns the interval between two events at the given rate, or one second if the rate is zero,
+// e.g. as the step of Clock.Run to let events allowed at the given rate go through one at a time.
+func Interval(rate ratelimit.Rate) time.Duration {
+	if rate == 0 {
+		return time.Second
+	}
+	return time.Duration(float64(time.Second) / float64(rate))
+}
+
+// TickerOffsets returns the offsets at which n events are allowed by a limiter without burst at the given rate,
+// to be checked with AssertTimes.
+// Like a ticker, the first event is allowed after one interval.
+// If the rate is zero, every event is allowed immediately.
+func TickerOffsets(n int, rate ratelimit.Rate) []time.Duration {
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		if rate != 0 {
+			offsets[i] = time.Duration(float64(i+1) / float64(rate) * float64(time.Second))
+		}
+	}
+	return offsets
+}
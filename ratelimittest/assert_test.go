@@ -0,0 +1,145 @@
+package ratelimittest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+// recorder captures the failures reported by assertions.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// events returns events of cost 1 happening at the given offsets from start.
+func events(start time.Time, offsets ...time.Duration) []ratelimittest.Event {
+	es := make([]ratelimittest.Event, len(offsets))
+	for i, o := range offsets {
+		es[i] = ratelimittest.Event{At: start.Add(o), N: 1}
+	}
+	return es
+}
+
+func TestObserved(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	es := events(start, 0, 0, 0, time.Second, 2*time.Second)
+
+	if got := ratelimittest.ObservedRate(es); got != 2 {
+		t.Errorf("got observed rate %v, expected 2 QPS", got)
+	}
+	if got := ratelimittest.ObservedBurst(es); got != 3 {
+		t.Errorf("got observed burst %d, expected 3", got)
+	}
+	if got := ratelimittest.ObservedRate(es[:1]); got != 0 {
+		t.Errorf("got observed rate %v for a single event, expected 0", got)
+	}
+}
+
+func TestAssertConforms(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+
+	testCases := []struct {
+		name   string
+		events []ratelimittest.Event
+		rate   ratelimit.Rate
+		burst  int
+		fails  bool
+	}{
+		{name: "steady", events: events(start, 0, time.Second, 2*time.Second), rate: 1, burst: 1},
+		{name: "burst then steady", events: events(start, 0, 0, time.Second, 2*time.Second), rate: 1, burst: 2},
+		{name: "too fast", events: events(start, 0, 500*time.Millisecond), rate: 1, burst: 1, fails: true},
+		{name: "burst too large", events: events(start, 0, 0, 0), rate: 1, burst: 2, fails: true},
+		{name: "no rate limit", events: events(start, 0, 0, 0), rate: 0, burst: 1},
+		{name: "costly event paid back", events: []ratelimittest.Event{{At: start, N: 3}, {At: start.Add(3 * time.Second), N: 1}}, rate: 1, burst: 1},
+		{name: "costly event not paid back", events: []ratelimittest.Event{{At: start, N: 3}, {At: start.Add(time.Second), N: 1}}, rate: 1, burst: 1, fails: true},
+	}
+
+	for _, tc := range testCases {
+		r := &recorder{TB: t}
+		ratelimittest.AssertConforms(r, tc.events, tc.rate, tc.burst)
+
+		if failed := len(r.failures) > 0; failed != tc.fails {
+			t.Errorf("%s - got failures %q, expected failure: %t", tc.name, r.failures, tc.fails)
+		}
+	}
+}
+
+func TestAssertRate(t *testing.T) {
+	t.Parallel()
+
+	es := events(time.Now(), 0, time.Second, 2*time.Second)
+
+	r := &recorder{TB: t}
+	ratelimittest.AssertRate(r, es, 1.05, 0.1)
+	if len(r.failures) != 0 {
+		t.Errorf("got failures %q for a rate within tolerance", r.failures)
+	}
+
+	ratelimittest.AssertRate(r, es, 2, 0.1)
+	if len(r.failures) != 1 {
+		t.Errorf("got failures %q, expected one for a rate out of tolerance", r.failures)
+	}
+}
+
+func TestAssertTimes(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	es := events(start, 0, time.Second)
+
+	r := &recorder{TB: t}
+	ratelimittest.AssertTimes(r, es, start, 0, time.Second)
+	if len(r.failures) != 0 {
+		t.Errorf("got failures %q for matching times", r.failures)
+	}
+
+	ratelimittest.AssertTimes(r, es, start, 0, 2*time.Second)
+	if len(r.failures) != 1 {
+		t.Errorf("got failures %q, expected one for a mismatching time", r.failures)
+	}
+
+	r.failures = nil
+	ratelimittest.AssertTimes(r, es, start, 0)
+	if len(r.failures) != 1 {
+		t.Errorf("got failures %q, expected one for a missing time", r.failures)
+	}
+}
+
+func TestTickerOffsets(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		rate     ratelimit.Rate
+		interval time.Duration
+		offsets  []time.Duration
+	}{
+		{name: "2 QPS", rate: 2, interval: 500 * time.Millisecond, offsets: []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond}},
+		{name: "no rate limit", rate: 0, interval: time.Second, offsets: []time.Duration{0, 0, 0}},
+	}
+
+	for _, tc := range testCases {
+		if got := ratelimittest.Interval(tc.rate); got != tc.interval {
+			t.Errorf("%s: got interval %v, expected %v", tc.name, got, tc.interval)
+		}
+
+		got := ratelimittest.TickerOffsets(len(tc.offsets), tc.rate)
+		if fmt.Sprint(got) != fmt.Sprint(tc.offsets) {
+			t.Errorf("%s: got offsets %v, expected %v", tc.name, got, tc.offsets)
+		}
+	}
+}
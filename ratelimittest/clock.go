@@ -0,0 +1,196 @@
+// Package ratelimittest provides utilities for testing code relying on rate limiters.
+package ratelimittest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+// Clock is a fake ratelimit.Clock whose time only moves when told to,
+// so that rate limited code can be tested instantly and deterministically.
+// Functions scheduled with AfterFunc are called synchronously by Advance, in time order.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*timer
+	changed chan struct{}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// AfterFunc schedules f to be called once the clock has been advanced by d.
+func (c *Clock) AfterFunc(d time.Duration, f func()) ratelimit.Timer {
+	t := &timer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, calling the functions scheduled until then in time order.
+// Each function is called with the clock set to its scheduled time.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			if end.After(c.now) {
+				c.now = end
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		t := c.timers[0]
+		c.remove(t)
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+
+		c.mu.Unlock()
+
+		t.f()
+	}
+}
+
+// settle is the real time Run lets goroutines react to the current time before advancing the clock,
+// e.g. for a waiter woken up by the previous step to record the time it was allowed at.
+const settle = 2 * time.Millisecond
+
+// Run calls f and advances the clock by step whenever a function is scheduled on it, until f returns,
+// e.g. to let rate limited calls made by f go through as if time was passing.
+// Before each step, goroutines are given a short real time to react to the current time.
+func (c *Clock) Run(step time.Duration, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		c.mu.Lock()
+		scheduled := len(c.timers) > 0
+		changed := c.changed
+		c.mu.Unlock()
+
+		if scheduled {
+			select {
+			case <-done:
+				return
+			case <-time.After(settle):
+			}
+
+			c.Advance(step)
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// Timers returns the number of functions scheduled and not called yet.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n functions are scheduled,
+// e.g. until a limiter waits for the clock to wake up n waiters.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) >= n {
+			c.mu.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		<-changed
+	}
+}
+
+// schedule inserts the timer in the timers sorted by time.
+func (c *Clock) schedule(t *timer) {
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].when.After(t.when) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// remove removes the timer from the scheduled timers.
+// It reports whether the timer was scheduled.
+func (c *Clock) remove(t *timer) bool {
+	for i, s := range c.timers {
+		if s == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// timer is a function scheduled on a fake clock.
+type timer struct {
+	clock *Clock
+	when  time.Time
+	f     func()
+}
+
+// Stop prevents the function from being called.
+// It reports whether the function was still scheduled.
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.remove(t)
+}
+
+// Reset schedules the function to be called once the clock has been advanced by d.
+// It reports whether the function was still scheduled.
+func (t *timer) Reset(d time.Duration) bool {
+	c := t.clock
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	scheduled := c.remove(t)
+	t.when = c.now.Add(d)
+	c.schedule(t)
+
+	return scheduled
+}
+
+// NewClock returns a fake clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimittest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestClockAdvance(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := ratelimittest.NewClock(start)
+
+	var calls []time.Duration
+	record := func() { calls = append(calls, clock.Now().Sub(start)) }
+
+	clock.AfterFunc(3*time.Second, record)
+	clock.AfterFunc(time.Second, record)
+	stopped := clock.AfterFunc(2*time.Second, record)
+	reset := clock.AfterFunc(time.Hour, record)
+
+	if !stopped.Stop() {
+		t.Fatal("stop reported the function as not scheduled")
+	}
+	reset.Reset(1500 * time.Millisecond)
+
+	if got := clock.Timers(); got != 3 {
+		t.Fatalf("got %d scheduled functions, expected 3", got)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	expected := []time.Duration{time.Second, 1500 * time.Millisecond}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("functions called at %v, expected %v", calls, expected)
+	}
+
+	if got := clock.Now().Sub(start); got != 2*time.Second {
+		t.Fatalf("clock advanced by %v, expected 2s", got)
+	}
+
+	clock.Advance(time.Second)
+
+	expected = append(expected, 3*time.Second)
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("functions called at %v, expected %v", calls, expected)
+	}
+}
+
+func TestClockLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Hour), 1, ratelimit.WithClock(clock))
+	defer l.Close()
+
+	if !l.Allow() {
+		t.Fatal("event refused, expected the bucket to start full")
+	}
+
+	done := make(chan time.Time)
+	go func() {
+		l.Wait(context.Background())
+		done <- clock.Now()
+	}()
+
+	// The limiter schedules the wake-up of the waiter on the clock.
+	clock.BlockUntil(1)
+	start := clock.Now()
+	clock.Advance(time.Hour)
+
+	if got := (<-done).Sub(start); got != time.Hour {
+		t.Fatalf("waiter woken up after %v, expected 1h", got)
+	}
+}
+
+func TestClockRun(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1, ratelimit.WithClock(clock))
+	defer l.Close()
+
+	start := clock.Now()
+
+	clock.Run(time.Minute, func() {
+		for i := 0; i < 3; i++ {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	// The bucket starts full: only the last two waits go through the clock.
+	if got := clock.Now().Sub(start); got != 2*time.Minute {
+		t.Fatalf("clock advanced by %v, expected 2m", got)
+	}
+}
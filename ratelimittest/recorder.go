@@ -0,0 +1,121 @@
+package ratelimittest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+// Event is an event allowed by a limiter or observed by a test server.
+type Event struct {
+	// At is the time at which the event happened.
+	At time.Time
+	// N is the number of limiter slots consumed by the event.
+	N int
+}
+
+// RecordingLimiter is a limiter recording the events allowed by the limiter it wraps,
+// so that tests can assert the rate at which a client, worker or proxy acted.
+type RecordingLimiter struct {
+	limiter ratelimit.Limiter
+	clock   ratelimit.Clock
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// Wait waits on the wrapped limiter and records the event if it is allowed.
+func (l *RecordingLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN waits on the wrapped limiter and records the event costing n slots if it is allowed.
+func (l *RecordingLimiter) WaitN(ctx context.Context, n int) error {
+	if err := l.limiter.WaitN(ctx, n); err != nil {
+		return err
+	}
+	l.record(n)
+	return nil
+}
+
+// Allow asks the wrapped limiter and records the event if it is allowed.
+func (l *RecordingLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN asks the wrapped limiter and records the event costing n slots if it is allowed.
+func (l *RecordingLimiter) AllowN(n int) bool {
+	if !l.limiter.AllowN(n) {
+		return false
+	}
+	l.record(n)
+	return true
+}
+
+// Reserve reserves a slot on the wrapped limiter.
+// The event is recorded once a wait on the reservation succeeds.
+func (l *RecordingLimiter) Reserve() ratelimit.Reservation {
+	return l.ReserveN(1)
+}
+
+// ReserveN reserves n slots on the wrapped limiter.
+// The event is recorded once a wait on the reservation succeeds.
+func (l *RecordingLimiter) ReserveN(n int) ratelimit.Reservation {
+	return &recordingReservation{
+		Reservation: l.limiter.ReserveN(n),
+		limiter:     l,
+		n:           n,
+	}
+}
+
+// Close closes the wrapped limiter if it holds resources to release.
+func (l *RecordingLimiter) Close() error {
+	if c, ok := l.limiter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Events returns the events recorded so far, in the order they were allowed.
+func (l *RecordingLimiter) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Event(nil), l.events...)
+}
+
+func (l *RecordingLimiter) record(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, Event{At: l.clock.Now(), N: n})
+}
+
+// recordingReservation records its event once waited for.
+type recordingReservation struct {
+	ratelimit.Reservation
+	limiter *RecordingLimiter
+	n       int
+	once    sync.Once
+}
+
+// Wait waits for the reservation and records its event the first time it succeeds.
+func (r *recordingReservation) Wait(ctx context.Context) error {
+	if err := r.Reservation.Wait(ctx); err != nil {
+		return err
+	}
+	r.once.Do(func() { r.limiter.record(r.n) })
+	return nil
+}
+
+// NewRecordingLimiter returns a limiter recording the events allowed by the given limiter at the time of the given clock,
+// usually the fake clock the limiter runs on.
+func NewRecordingLimiter(l ratelimit.Limiter, clock ratelimit.Clock) *RecordingLimiter {
+	return &RecordingLimiter{
+		limiter: l,
+		clock:   clock,
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimittest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestRecordingLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewTokenBucket(ratelimit.Per(1, time.Second), 2, ratelimit.WithClock(clock)), clock)
+	defer l.Close()
+
+	start := clock.Now()
+
+	clock.Run(time.Second, func() {
+		if !l.AllowN(2) {
+			t.Error("event refused, expected the bucket to start full")
+		}
+		if l.Allow() {
+			t.Error("event allowed, expected the bucket to be empty")
+		}
+
+		if err := l.Wait(context.Background()); err != nil {
+			t.Error(err)
+		}
+
+		r := l.Reserve()
+		if err := r.Wait(context.Background()); err != nil {
+			t.Error(err)
+		}
+		r.Wait(context.Background())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := l.Wait(ctx); err == nil {
+			t.Error("wait with a canceled context succeeded")
+		}
+	})
+
+	expected := []ratelimittest.Event{
+		{At: start, N: 2},
+		{At: start.Add(time.Second), N: 1},
+		{At: start.Add(2 * time.Second), N: 1},
+	}
+
+	events := l.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("got %d events, expected %d", len(events), len(expected))
+	}
+	for i, e := range events {
+		if !e.At.Equal(expected[i].At) || e.N != expected[i].N {
+			t.Errorf("event %d: got %d slots at %v, expected %d slots at %v", i+1, e.N, e.At.Sub(start), expected[i].N, expected[i].At.Sub(start))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ratelimittest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/tgirier/ratelimit"
+)
+
+// Server is a stub upstream server recording the time at which each request arrives,
+// so that tests can assert the rate at which a client or proxy sends requests.
+// It embeds the underlying httptest.Server, which should be closed once the test is over.
+type Server struct {
+	*httptest.Server
+
+	clock   ratelimit.Clock
+	handler http.Handler
+
+	mu       sync.Mutex
+	arrivals []Event
+}
+
+// ServeHTTP records the arrival of the request and serves it with the server handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.arrivals = append(s.arrivals, Event{At: s.clock.Now(), N: 1})
+	s.mu.Unlock()
+
+	s.handler.ServeHTTP(w, r)
+}
+
+// Arrivals returns the arrivals of the requests received so far, in order, each as an event of cost 1.
+func (s *Server) Arrivals() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Event(nil), s.arrivals...)
+}
+
+// NewServer starts a stub upstream server recording request arrivals at the time of the given clock.
+// Requests are served by the given handler or, if nil, answered with "OK".
+func NewServer(clock ratelimit.Clock, h http.Handler) *Server {
+	s := newServer(clock, h)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts a stub upstream TLS server recording request arrivals at the time of the given clock.
+// Requests are served by the given handler or, if nil, answered with "OK".
+func NewTLSServer(clock ratelimit.Clock, h http.Handler) *Server {
+	s := newServer(clock, h)
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer(clock ratelimit.Clock, h http.Handler) *Server {
+	if h == nil {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "OK")
+		})
+	}
+
+	return &Server{
+		clock:   clock,
+		handler: h,
+	}
+}
@@ -0,0 +1,43 @@
+package ratelimittest_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewTLSServer(clock, nil)
+	defer ts.Close()
+
+	c := ratelimit.New(ratelimit.Per(2, time.Second), ratelimit.WithClock(clock))
+	defer c.Close()
+	c.Transport = ts.Client().Transport
+
+	start := clock.Now()
+
+	clock.Run(500*time.Millisecond, func() {
+		for i := 0; i < 3; i++ {
+			resp, err := c.GetWithRateLimit(ts.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			b, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(b) != "OK" {
+				t.Errorf("got body %q (%v), expected OK", b, err)
+			}
+		}
+	})
+
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, 500*time.Millisecond, time.Second, 1500*time.Millisecond)
+}
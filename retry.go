@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryStatusCodes are the response status codes retried by a RetryPolicy without StatusCodes.
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Default backoff delays of a RetryPolicy.
+const (
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 10 * time.Second
+)
+
+// RetryPolicy makes an HTTP client retry failed requests with an exponential backoff and full jitter:
+// before the nth retry, the client sleeps for a random duration between zero and BaseDelay * 2^(n-1), capped at MaxDelay.
+// Every retry waits for the client limiter like any other request, so retries never exceed the configured rate.
+// Requests with a body are only retried if their body can be rewound with GetBody.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent, the first attempt included.
+	// If lower than 2, requests are not retried.
+	MaxAttempts int
+
+	// StatusCodes are the response status codes for which a request is retried.
+	// If nil, DefaultRetryStatusCodes are retried.
+	StatusCodes []int
+
+	// NetworkErrors makes the client retry requests failing with a transport error, e.g. a connection reset.
+	// Requests are never retried once their context is done.
+	NetworkErrors bool
+
+	// BaseDelay is the backoff delay before the first retry.
+	// If zero, DefaultRetryBaseDelay is used.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff delay between two attempts.
+	// If zero, DefaultRetryMaxDelay is used.
+	MaxDelay time.Duration
+}
+
+// retryable reports whether the request should be sent again after the given attempt
+// returned the given response and error.
+func (p *RetryPolicy) retryable(req *http.Request, attempt int, resp *http.Response, err error) bool {
+	if attempt+1 >= p.MaxAttempts || req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return p.NetworkErrors && !errors.Is(err, ErrLimiterClosed) && !errors.Is(err, context.Canceled) &&
+			!errors.Is(err, context.DeadlineExceeded)
+	}
+
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a random delay to wait before retrying the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+
+	d := max
+	if attempt < 62 && base < max>>uint(attempt) {
+		d = base << uint(attempt)
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// RetryAfterPolicy makes an HTTP client honor the Retry-After header of 429 (Too Many Requests)
+// and 503 (Service Unavailable) responses.
+// The client limiter, or the limiter of the responding host when limiting per host,
+// is paused until the time requested by the server, for every goroutine sharing it,
+// and the request is optionally retried once the pause is over.
+// If the limiter cannot be paused, the client waits by itself before retrying.
+type RetryAfterPolicy struct {
+	// MaxRetries is the maximum number of times a request is transparently retried.
+	// If zero, the response is returned to the caller once the limiter is paused.
+	MaxRetries int
+
+	// MaxDelay is the longest Retry-After delay after which a request is retried.
+	// Longer delays still pause the limiter but the response is returned to the caller.
+	// If zero, any delay is retried.
+	MaxDelay time.Duration
+}
+
+// retryAfter returns the time until which the server asks clients to back off.
+// It reports false if the response does not ask for it.
+func (p *RetryAfterPolicy) retryAfter(resp *http.Response, now time.Time) (time.Time, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return time.Time{}, false
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"), now)
+}
+
+// retry reports whether a request should be retried after the given time.
+func (p *RetryAfterPolicy) retry(attempt int, until, now time.Time) bool {
+	return attempt < p.MaxRetries && p.waitable(until, now)
+}
+
+// waitable reports whether the delay until the given time is short enough to wait for.
+func (p *RetryAfterPolicy) waitable(until, now time.Time) bool {
+	return p.MaxDelay == 0 || until.Sub(now) <= p.MaxDelay
+}
+
+// maxRetryAfterSeconds is the largest number of seconds a time.Duration can hold.
+const maxRetryAfterSeconds = math.MaxInt64 / int64(time.Second)
+
+// parseRetryAfter parses a Retry-After header value, either a number of seconds or an HTTP date.
+// Delays too long to be represented are clamped to the longest duration.
+// It reports false if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		if seconds > maxRetryAfterSeconds {
+			seconds = maxRetryAfterSeconds
+		}
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if t.Before(now) {
+		return now, true
+	}
+	return t, true
+}
+
+// rewindRequest returns a copy of the request with a fresh body so that it can be sent again.
+// It reports false if the body cannot be rewound.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, true
+}
+
+// sleep pauses the current goroutine for the given duration of the clock or until the context is done.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	woken := make(chan struct{})
+	t := clock.AfterFunc(d, func() { close(woken) })
+	defer t.Stop()
+
+	select {
+	case <-woken:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discardResponse drains and closes the body of a response that will not be returned,
+// allowing the underlying connection to be reused.
+func discardResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
@@ -0,0 +1,372 @@
+package ratelimit_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+	"github.com/tgirier/ratelimit/ratelimittest"
+)
+
+func TestRetryAfterRetries(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	var mu sync.Mutex
+	var bodies []string
+
+	ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		hits := len(bodies)
+		mu.Unlock()
+
+		if hits == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10, ratelimit.WithClock(clock))
+
+	c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithClock(clock), ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{MaxRetries: 1}))
+	defer c.Close()
+
+	start := clock.Now()
+
+	var resp *http.Response
+	var err error
+	clock.Run(100*time.Millisecond, func() {
+		resp, err = c.PostWithRateLimit(ts.URL, "text/plain", strings.NewReader("payload"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, 0, time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(bodies) != 2 {
+		t.Fatalf("server received %d requests, expected 2", len(bodies))
+	}
+
+	for i, b := range bodies {
+		if b != "payload" {
+			t.Fatalf("request %d body %q, expected %q", i, b, "payload")
+		}
+	}
+}
+
+func TestRetryAfterPausesSharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", clock.Now().Add(2*time.Second).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10, ratelimit.WithClock(clock))
+
+	c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithClock(clock), ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{}))
+	defer c.Close()
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, expected %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	w := ratelimit.NewWorkerWithLimiter(l, func() {})
+	if err := w.TryDoWithRateLimit(); err != ratelimit.ErrRateLimited {
+		t.Fatalf("got error %v from a worker sharing the paused limiter, expected %v", err, ratelimit.ErrRateLimited)
+	}
+
+	r := l.Reserve()
+	defer r.Cancel()
+
+	// HTTP dates drop the fraction of a second.
+	if d := r.Delay(); d <= time.Second || d > 2*time.Second {
+		t.Fatalf("got delay %v, expected the limiter to be paused for 1 to 2s", d)
+	}
+}
+
+func TestRetryAfterOverflow(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// More seconds than a time.Duration can hold.
+		w.Header().Set("Retry-After", "10000000000")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(100, time.Second), 10, ratelimit.WithClock(clock))
+
+	c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithClock(clock), ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{}))
+	defer c.Close()
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	r := l.Reserve()
+	defer r.Cancel()
+
+	if d, min := r.Delay(), 100*365*24*time.Hour; d < min {
+		t.Fatalf("got delay %v, expected the limiter to be paused for at least %v", d, min)
+	}
+}
+
+func TestRetryAfterLimiterNotPausable(t *testing.T) {
+	t.Parallel()
+
+	clock := ratelimittest.NewClock(time.Now())
+
+	hits := 0
+	ts := ratelimittest.NewServer(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits++; hits == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	// A recording limiter cannot be paused: the client must wait by itself.
+	l := ratelimittest.NewRecordingLimiter(ratelimit.NewLimiter(0), clock)
+
+	c := ratelimit.New(0, ratelimit.WithLimiter(l), ratelimit.WithClock(clock), ratelimit.WithRetryAfter(ratelimit.RetryAfterPolicy{MaxRetries: 1}))
+	defer c.Close()
+
+	start := clock.Now()
+
+	clock.Run(time.Second, func() {
+		resp, err := c.GetWithRateLimit(ts.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("got status %d, expected %d", resp.StatusCode, http.StatusOK)
+		}
+	})
+
+	ratelimittest.AssertTimes(t, ts.Arrivals(), start, 0, 2*time.Second)
+}
+
+func TestRetryPolicyStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var bodies []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		hits := len(bodies)
+		mu.Unlock()
+
+		if hits < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 3)
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+	c.Retry = &ratelimit.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	resp, err := c.PostWithRateLimit(ts.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if l.Allow() {
+		t.Fatal("event allowed after 3 attempts, expected every attempt to consume a slot")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(bodies) != 3 {
+		t.Fatalf("server received %d requests, expected 3", len(bodies))
+	}
+
+	for i, b := range bodies {
+		if b != "payload" {
+			t.Fatalf("request %d body %q, expected %q", i, b, "payload")
+		}
+	}
+}
+
+func TestRetryPolicyMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var hits int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name   string
+		policy ratelimit.RetryPolicy
+		hits   int
+	}{
+		{name: "retried status", policy: ratelimit.RetryPolicy{MaxAttempts: 2, StatusCodes: []int{500}, BaseDelay: time.Millisecond}, hits: 2},
+		{name: "default status codes", policy: ratelimit.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}, hits: 1},
+		{name: "single attempt", policy: ratelimit.RetryPolicy{MaxAttempts: 1, StatusCodes: []int{500}}, hits: 1},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			hits = 0
+			mu.Unlock()
+
+			c := ratelimit.NewHTTPClient(0)
+			defer c.Close()
+			c.Retry = &tc.policy
+
+			resp, err := c.GetWithRateLimit(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("got status %d, expected %d", resp.StatusCode, http.StatusInternalServerError)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if hits != tc.hits {
+				t.Fatalf("server received %d requests, expected %d", hits, tc.hits)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyNetworkErrors(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var hits int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		first := hits == 1
+		mu.Unlock()
+
+		if first {
+			// Drop the connection without any response.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	c := ratelimit.NewHTTPClient(0)
+	defer c.Close()
+	c.Retry = &ratelimit.RetryPolicy{MaxAttempts: 2, NetworkErrors: true, BaseDelay: time.Millisecond}
+
+	resp, err := c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatalf("got %v, expected the request to be retried after a network error", err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if hits != 2 {
+		t.Fatalf("server received %d requests, expected 2", hits)
+	}
+}
+
+func TestRetryPolicyBodyNotRewindable(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var hits int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := ratelimit.NewHTTPClient(0)
+	defer c.Close()
+	c.Retry = &ratelimit.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	// The request body does not support GetBody.
+	req, err := http.NewRequest(http.MethodPost, ts.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.DoWithRateLimit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if hits != 1 {
+		t.Fatalf("server received %d requests, expected 1", hits)
+	}
+}
@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper rate limiting every request it sends.
+// Assigned to the Transport of an http.Client, it limits every request issued by the client,
+// including redirects and requests issued by libraries unaware of rate limiting.
+type Transport struct {
+	// Base is the transport used to send requests.
+	// If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Limiter limits the requests sent by the transport.
+	// If nil, requests are not limited.
+	Limiter Limiter
+
+	// PerRequest selects an additional limiter for each request, e.g. the limiter of the request host.
+	// If nil, requests are only limited by Limiter.
+	PerRequest RequestLimiter
+
+	// Concurrency caps the number of requests in flight.
+	// A slot is held until the response body is closed.
+	// If nil, the number of requests in flight is not limited.
+	Concurrency ConcurrencyLimiter
+
+	// ReadLimiter limits the rate at which response bodies are read, each byte consuming one slot.
+	// If nil, response bodies are not limited.
+	ReadLimiter Limiter
+
+	// WriteLimiter limits the rate at which request bodies are sent, each byte consuming one slot.
+	// If nil, request bodies are not limited.
+	WriteLimiter Limiter
+
+	// Cost returns the number of limiter slots consumed by a request.
+	// It is overridden by a cost set on the request context with WithCost.
+	// If both are unset, every request consumes a single slot.
+	// A zero cost returned by Cost lets the request through without waiting for the limiter
+	// and a negative cost fails with ErrInvalidCost.
+	Cost func(req *http.Request) int
+
+	// done is closed when the client owning the transport is closed, releasing the requests waiting for the limiter.
+	done chan struct{}
+}
+
+// RoundTrip waits for the limiter, then for a concurrency slot, before sending the request with the base transport.
+// Requests waiting for the limiter do not hold a concurrency slot, so that they do not block requests to other hosts.
+// If the request context ends while waiting, the context error is returned without consuming any slot.
+// The concurrency slot is held until the response body is closed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.reserve(req)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	// Requests admitted by a client, redirects included, already hold a concurrency slot.
+	opts, _ := req.Context().Value(requestOptionsKey{}).(requestOptions)
+	if t.Concurrency == nil || opts.admittedBy == t {
+		return t.send(req)
+	}
+
+	if err := t.Concurrency.Acquire(req.Context(), req); err != nil {
+		if r != nil {
+			r.Cancel()
+		}
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	resp, err := t.send(req)
+	return holdUntilClosed(resp, err, func() { t.Concurrency.Release(req) })
+}
+
+// send sends a request allowed by the limiter with the base transport, limiting the bandwidth of its bodies.
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	if t.WriteLimiter != nil {
+		req = limitRequestBody(req, t.WriteLimiter)
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err == nil && t.ReadLimiter != nil {
+		limitResponseBody(req.Context(), resp, t.ReadLimiter)
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the base transport if it supports it.
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	if ci, ok := t.base().(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// reserve waits for the limiter unless the request has already been admitted by a client sharing this transport,
+// and returns the granted reservation, if any, so that its slots can be given back if the request is not sent.
+// Redirects are never considered admitted.
+func (t *Transport) reserve(req *http.Request) (Reservation, error) {
+	l := limiterFor(t.Limiter, t.PerRequest, req)
+	if l == nil {
+		return nil, nil
+	}
+
+	opts, _ := req.Context().Value(requestOptionsKey{}).(requestOptions)
+	if opts.admittedBy == t && req.Response == nil {
+		return nil, nil
+	}
+
+	cost := opts.cost
+	if cost == 0 {
+		cost = 1
+		if t.Cost != nil {
+			cost = t.Cost(req)
+		}
+	}
+
+	switch {
+	case cost < 0:
+		return nil, ErrInvalidCost
+	case cost == 0:
+		return nil, nil
+	}
+	return reserveOpen(req.Context(), l, cost, t.done)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+// closeRequestBody closes the body of a request that will not be sent, as required from round trippers.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// limiterFor returns the limiter applied to the request:
+// the given limiter combined with the one selected by the request limiter, if any.
+func limiterFor(l Limiter, rl RequestLimiter, req *http.Request) Limiter {
+	if rl == nil {
+		return l
+	}
+	if l == nil {
+		return rl.LimiterFor(req)
+	}
+	return NewMultiLimiter(l, rl.LimiterFor(req))
+}
+
+// requestOptions tune how a Transport limits the requests issued with a given context.
+type requestOptions struct {
+	cost       int
+	admittedBy *Transport
+}
+
+type requestOptionsKey struct{}
+
+// WithCost returns a copy of the context making requests issued with it consume cost limiter slots
+// when sent through a Transport, redirects included.
+// A zero cost leaves the cost unset and a negative cost makes requests fail with ErrInvalidCost.
+func WithCost(ctx context.Context, cost int) context.Context {
+	opts, _ := ctx.Value(requestOptionsKey{}).(requestOptions)
+	opts.cost = cost
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// admitted returns a copy of the context marking requests issued with it as already admitted by the given transport limiter.
+// Follow-up redirects still consume cost limiter slots.
+func admitted(ctx context.Context, t *Transport, cost int) context.Context {
+	opts, _ := ctx.Value(requestOptionsKey{}).(requestOptions)
+	opts.cost = cost
+	opts.admittedBy = t
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// NewTransport returns a transport sending requests with the base transport, rate limited by the given limiter.
+// If the base transport is nil, http.DefaultTransport is used.
+func NewTransport(l Limiter, base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:    base,
+		Limiter: l,
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tgirier/ratelimit"
+)
+
+func TestTransportLimitsRedirects(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.Handle("/redirect", http.RedirectHandler("/hello", http.StatusFound))
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 2)
+
+	// A plain http client is limited once its transport is replaced.
+	c := ts.Client()
+	c.Transport = ratelimit.NewTransport(l, c.Transport)
+
+	resp, err := c.Get(ts.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if l.Allow() {
+		t.Fatal("event allowed after a redirected request, expected both hops to consume a slot")
+	}
+}
+
+func TestTransportContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 1)
+	l.Allow()
+
+	c := &http.Client{Transport: ratelimit.NewTransport(l, nil)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Do(req); err == nil {
+		t.Fatal("request sent while the limiter was exhausted")
+	}
+}
+
+func TestHTTPClientDefaultTransport(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	defer ts.Close()
+
+	l := ratelimit.NewTokenBucket(ratelimit.Per(1, time.Minute), 3)
+
+	c := ratelimit.NewHTTPClientWithLimiter(l)
+
+	// Plain http.Client methods are limited by the client transport.
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// RateLimit methods consume a single slot although the transport is limited too.
+	resp, err = c.GetWithRateLimit(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !l.Allow() {
+		t.Fatal("event not allowed, expected a single slot consumed per request")
+	}
+
+	if l.Allow() {
+		t.Fatal("event allowed, expected every slot to be consumed")
+	}
+}